@@ -0,0 +1,128 @@
+package nexus
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const principalContextKey contextKey = "nexus.principal"
+
+// Principal is the authenticated identity that the auth middlewares store in the request context
+type Principal struct {
+	Subject string
+	Method  string // Method is the auth middleware that authenticated the request (jwt, secret, basic...)
+	Scopes  []string
+	Roles   []string
+}
+
+// ContextWithPrincipal store the principal in the context; custom auth middlewares should call it
+// so the Authorize middleware can check their identities
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext return the principal stored by the active auth middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok
+}
+
+// HasScope evaluate if the principal has the scope
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// HasRole evaluate if the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+// Authorize check the RequiredScopes, AnyScopes, RequiredRoles and AnyRoles of the endpoint against
+// the principal of the request; it must be registered after the auth middleware:
+//
+//	server.Use(nexus.JWTAuth(options), nexus.Authorize)
+func Authorize(next http.Handler, server *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := server.GetEndpoint(r)
+		if !ok || !endpoint.Options.requiresAuthorization() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			ResponseWithError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		missing := missingPermissions(endpoint.Options, principal)
+		if len(missing) > 0 {
+			ResponseJsonWithError(w, http.StatusForbidden, &ErrorResponse{
+				Code:     http.StatusForbidden,
+				Message:  "insufficient permissions",
+				CodeName: "forbidden",
+				Errors:   missing,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// missingPermissions return the scopes and roles the principal lacks, indexed by kind
+func missingPermissions(options EndpointOptions, principal *Principal) map[string]string {
+	missing := make(map[string]string)
+
+	var missingScopes []string
+	for _, scope := range options.RequiredScopes {
+		if !principal.HasScope(scope) {
+			missingScopes = append(missingScopes, scope)
+		}
+	}
+	if len(missingScopes) > 0 {
+		missing["scopes"] = strings.Join(missingScopes, " ")
+	}
+	if len(options.AnyScopes) > 0 && !containsAny(principal.Scopes, options.AnyScopes) {
+		missing["any_scopes"] = strings.Join(options.AnyScopes, " ")
+	}
+
+	var missingRoles []string
+	for _, role := range options.RequiredRoles {
+		if !principal.HasRole(role) {
+			missingRoles = append(missingRoles, role)
+		}
+	}
+	if len(missingRoles) > 0 {
+		missing["roles"] = strings.Join(missingRoles, " ")
+	}
+	if len(options.AnyRoles) > 0 && !containsAny(principal.Roles, options.AnyRoles) {
+		missing["any_roles"] = strings.Join(options.AnyRoles, " ")
+	}
+
+	return missing
+}
+
+func (options EndpointOptions) requiresAuthorization() bool {
+	return len(options.RequiredScopes) > 0 || len(options.AnyScopes) > 0 ||
+		len(options.RequiredRoles) > 0 || len(options.AnyRoles) > 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
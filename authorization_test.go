@@ -0,0 +1,118 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func authorizationServer() *Server {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /open"},
+		{Path: "GET /reports", Options: EndpointOptions{RequiredScopes: []string{"reports:read", "reports:export"}}},
+		{Path: "GET /admin", Options: EndpointOptions{AnyRoles: []string{"admin", "owner"}}},
+		{Path: "GET /mixed", Options: EndpointOptions{RequiredRoles: []string{"staff"}, AnyScopes: []string{"a", "b"}}},
+	})
+	return server
+}
+
+func serveAuthorized(server *Server, path string, principal *Principal) *httptest.ResponseRecorder {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Authorize(inner, server)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", path, nil)
+	if principal != nil {
+		r = r.WithContext(ContextWithPrincipal(r.Context(), principal))
+	}
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// --- Authorize ---
+
+func TestAuthorize_NoRequirements(t *testing.T) {
+	w := serveAuthorized(authorizationServer(), "/open", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestAuthorize_MissingPrincipal(t *testing.T) {
+	w := serveAuthorized(authorizationServer(), "/reports", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestAuthorize_AllOfScopes(t *testing.T) {
+	server := authorizationServer()
+
+	w := serveAuthorized(server, "/reports", &Principal{Scopes: []string{"reports:read", "reports:export"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	w = serveAuthorized(server, "/reports", &Principal{Scopes: []string{"reports:read"}})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.CodeName != "forbidden" {
+		t.Fatalf("expected forbidden, got %s", resp.CodeName)
+	}
+	if resp.Errors["scopes"] != "reports:export" {
+		t.Fatalf("expected missing reports:export, got %v", resp.Errors)
+	}
+}
+
+func TestAuthorize_AnyOfRoles(t *testing.T) {
+	server := authorizationServer()
+
+	if w := serveAuthorized(server, "/admin", &Principal{Roles: []string{"owner"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := serveAuthorized(server, "/admin", &Principal{Roles: []string{"viewer"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestAuthorize_Mixed(t *testing.T) {
+	server := authorizationServer()
+
+	w := serveAuthorized(server, "/mixed", &Principal{Roles: []string{"staff"}, Scopes: []string{"b"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	w = serveAuthorized(server, "/mixed", &Principal{Scopes: []string{"c"}})
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Errors["roles"] != "staff" || resp.Errors["any_scopes"] != "a b" {
+		t.Fatalf("unexpected errors %v", resp.Errors)
+	}
+}
+
+func TestAuthorize_WithJWTAuth(t *testing.T) {
+	secret := []byte("s3cr3t")
+	server := authorizationServer()
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := JWTAuth(JWTOptions{Key: secret})(Authorize(inner, server), server)
+
+	claims := validClaims()
+	claims["scope"] = "reports:read reports:export"
+	r := httptest.NewRequest("GET", "/reports", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, "HS256", "", secret, claims))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
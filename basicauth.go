@@ -0,0 +1,405 @@
+package nexus
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const usernameContextKey contextKey = "nexus.username"
+
+// UserStore check the credentials of HTTP Basic authentication
+type UserStore interface {
+	Authenticate(username, password string) bool
+}
+
+// DigestUserStore return HA1 = MD5(username:realm:password) for HTTP Digest authentication
+type DigestUserStore interface {
+	HA1(username, realm string) (string, bool)
+}
+
+// UsernameFromContext return the user authenticated by the BasicAuth or DigestAuth middlewares
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(usernameContextKey).(string)
+	return username, ok
+}
+
+func contextWithUsername(r *http.Request, username, method string) *http.Request {
+	ctx := context.WithValue(r.Context(), usernameContextKey, username)
+	ctx = ContextWithPrincipal(ctx, &Principal{Subject: username, Method: method})
+	return r.WithContext(ctx)
+}
+
+// StaticUsers is a user store with plain text passwords indexed by username; it is meant for tests and development
+type StaticUsers map[string]string
+
+func (users StaticUsers) Authenticate(username, password string) bool {
+	expected, ok := users[username]
+	equal := subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	return ok && equal
+}
+
+func (users StaticUsers) HA1(username, realm string) (string, bool) {
+	password, ok := users[username]
+	if !ok {
+		return "", false
+	}
+	return md5Hex(username + ":" + realm + ":" + password), true
+}
+
+// Htpasswd is a user store loaded from an Apache htpasswd file; bcrypt ($2y$, $2a$, $2b$) and {SHA} hashes are supported
+type Htpasswd struct {
+	path  string
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// dummyHash is compared when the user does not exist, so unknown users take as long as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("nexus"), bcrypt.MinCost)
+
+// LoadHtpasswd read an htpasswd file; it fails when an entry uses a hash other than bcrypt and {SHA}
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload read the file again, so users can be changed without restarting the server
+func (h *Htpasswd) Reload() error {
+	users, err := readCredentialsFile(h.path, 2)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]string, len(users))
+	for _, fields := range users {
+		if !supportedHtpasswdHash(fields[1]) {
+			return fmt.Errorf("%s: unsupported hash of user %q; use bcrypt (htpasswd -B) or {SHA}", h.path, fields[0])
+		}
+		entries[fields[0]] = fields[1]
+	}
+	h.mu.Lock()
+	h.users = entries
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Htpasswd) Authenticate(username, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[username]
+	h.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// supportedHtpasswdHash evaluate if Authenticate can check the hash; $apr1$ (MD5), crypt and plain
+// text entries are rejected when the file is loaded, since their users could never log in
+func supportedHtpasswdHash(hash string) bool {
+	for _, prefix := range []string{"$2y$", "$2a$", "$2b$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Htdigest is a digest user store loaded from an Apache htdigest file (user:realm:HA1)
+type Htdigest struct {
+	entries map[string]string
+}
+
+// LoadHtdigest read an htdigest file
+func LoadHtdigest(path string) (*Htdigest, error) {
+	lines, err := readCredentialsFile(path, 3)
+	if err != nil {
+		return nil, err
+	}
+	h := &Htdigest{entries: make(map[string]string, len(lines))}
+	for _, fields := range lines {
+		h.entries[fields[0]+":"+fields[1]] = fields[2]
+	}
+	return h, nil
+}
+
+func (h *Htdigest) HA1(username, realm string) (string, bool) {
+	ha1, ok := h.entries[username+":"+realm]
+	return ha1, ok
+}
+
+// readCredentialsFile parse a colon separated credentials file, skipping comments and blank lines
+func readCredentialsFile(path string, fields int) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", fields)
+		if len(parts) != fields {
+			return nil, fmt.Errorf("%s:%d: invalid entry", path, n)
+		}
+		lines = append(lines, parts)
+	}
+	return lines, scanner.Err()
+}
+
+// BasicAuthOptions contains the configuration of the BasicAuth middleware
+type BasicAuthOptions struct {
+	Realm string
+	Users UserStore
+}
+
+// BasicAuth return a middleware that require HTTP Basic credentials on every endpoint
+// that is not marked as NoRequiresAuthentication
+func BasicAuth(options BasicAuthOptions) func(next http.Handler, server *Server) http.Handler {
+	if options.Realm == "" {
+		options.Realm = "nexus"
+	}
+	challenge := fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, options.Realm)
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if server.NoRequiresAuthentication(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			username, password, ok := r.BasicAuth()
+			if !ok || !options.Users.Authenticate(username, password) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				ResponseWithError(w, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+
+			next.ServeHTTP(w, contextWithUsername(r, username, "basic"))
+		})
+	}
+}
+
+// DigestAuthOptions contains the configuration of the DigestAuth middleware
+type DigestAuthOptions struct {
+	Realm    string
+	Users    DigestUserStore
+	NonceTTL time.Duration // NonceTTL is how long a nonce is accepted (default 5m)
+}
+
+// digestAuth issue and validate the nonces of RFC 7616 Digest authentication with MD5 and qop=auth
+type digestAuth struct {
+	options DigestAuthOptions
+	key     []byte
+
+	mu        sync.Mutex
+	counts    map[string]nonceCount // counts keep the last nonce count seen per nonce, to reject replays
+	lastSweep time.Time
+}
+
+type nonceCount struct {
+	count  uint64
+	issued time.Time
+}
+
+// DigestAuth return a middleware that require HTTP Digest credentials on every endpoint
+// that is not marked as NoRequiresAuthentication
+func DigestAuth(options DigestAuthOptions) func(next http.Handler, server *Server) http.Handler {
+	if options.Realm == "" {
+		options.Realm = "nexus"
+	}
+	if options.NonceTTL == 0 {
+		options.NonceTTL = 5 * time.Minute
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	digest := &digestAuth{options: options, key: key, counts: make(map[string]nonceCount), lastSweep: time.Now()}
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if server.NoRequiresAuthentication(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			username, stale, ok := digest.authenticate(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", digest.challenge(stale))
+				ResponseWithError(w, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+
+			next.ServeHTTP(w, contextWithUsername(r, username, "digest"))
+		})
+	}
+}
+
+func (d *digestAuth) challenge(stale bool) string {
+	challenge := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=MD5, nonce=%q, opaque=%q`,
+		d.options.Realm, d.nonce(time.Now()), md5Hex(d.options.Realm))
+	if stale {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+// nonce is the issue time signed with the server key, so no state is kept until the nonce is used
+func (d *digestAuth) nonce(now time.Time) string {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(ts))
+	return base64.RawURLEncoding.EncodeToString([]byte(ts + ":" + hex.EncodeToString(mac.Sum(nil))))
+}
+
+// checkNonce return the issue time of the nonce and whether it was issued by this server
+func (d *digestAuth) checkNonce(nonce string) (issued time.Time, valid bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		return time.Time{}, false
+	}
+	ts, signature, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, false
+	}
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(ts))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, unix), true
+}
+
+// authenticate verify the Authorization header; stale reports a correct response computed with an expired nonce
+func (d *digestAuth) authenticate(r *http.Request) (username string, stale bool, ok bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		return "", false, false
+	}
+	params := parseAuthParams(auth[len("Digest "):])
+
+	username = params["username"]
+	if params["realm"] != d.options.Realm || params["qop"] != "auth" || params["uri"] != r.URL.RequestURI() {
+		return "", false, false
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", false, false
+	}
+
+	now := time.Now()
+	issued, valid := d.checkNonce(params["nonce"])
+	if !valid {
+		return "", false, false
+	}
+	fresh := now.Sub(issued) <= d.options.NonceTTL
+
+	ha1, found := d.options.Users.HA1(username, d.options.Realm)
+	if !found {
+		return "", false, false
+	}
+	ha2 := md5Hex(r.Method + ":" + params["uri"])
+	expected := md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return "", false, false
+	}
+	if !fresh {
+		return "", true, false
+	}
+
+	// The nonce count must grow on every request made with the same nonce
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return "", false, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if nc <= d.counts[params["nonce"]].count {
+		return "", false, false
+	}
+	d.counts[params["nonce"]] = nonceCount{count: nc, issued: issued}
+	if now.Sub(d.lastSweep) >= d.options.NonceTTL {
+		d.sweep(now)
+	}
+
+	return username, false, true
+}
+
+// sweep forget the counts of expired nonces; it runs once per NonceTTL with the lock held
+func (d *digestAuth) sweep(now time.Time) {
+	d.lastSweep = now
+	for nonce, count := range d.counts {
+		if now.Sub(count.issued) > d.options.NonceTTL {
+			delete(d.counts, nonce)
+		}
+	}
+}
+
+// parseAuthParams parse the comma separated key=value (or key="value") pairs of an auth header
+func parseAuthParams(header string) map[string]string {
+	params := make(map[string]string)
+	for header != "" {
+		header = strings.TrimLeft(header, " ,")
+		key, rest, ok := strings.Cut(header, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			header = rest[min(i+1, len(rest)):]
+		} else {
+			value, header, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
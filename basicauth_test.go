@@ -0,0 +1,185 @@
+package nexus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basicAuthServer() *Server {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /admin"},
+		{Path: "GET /status", Options: EndpointOptions{NoRequiresAuthentication: true}},
+	})
+	return server
+}
+
+func usernameHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, _ := UsernameFromContext(r.Context())
+		w.Write([]byte(username))
+	})
+}
+
+// --- BasicAuth ---
+
+func TestBasicAuth(t *testing.T) {
+	server := basicAuthServer()
+	handler := BasicAuth(BasicAuthOptions{Realm: "admin", Users: StaticUsers{"ana": "pw"}})(usernameHandler(), server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for NoRequiresAuthentication endpoint, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, `Basic realm="admin"`) {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.SetBasicAuth("ana", "wrong")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong password, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/admin", nil)
+	r.SetBasicAuth("ana", "pw")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "ana" {
+		t.Fatalf("expected 200 with username, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// --- Htpasswd ---
+
+func TestHtpasswd(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := fmt.Sprintf("# users\nana:%s\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", hash)
+	os.WriteFile(path, []byte(content), 0o600)
+
+	store, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !store.Authenticate("ana", "secret") {
+		t.Fatal("expected bcrypt password to match")
+	}
+	if store.Authenticate("ana", "nope") {
+		t.Fatal("expected wrong bcrypt password to fail")
+	}
+	if !store.Authenticate("bob", "secret") {
+		t.Fatal("expected {SHA} password to match")
+	}
+	if store.Authenticate("carl", "secret") {
+		t.Fatal("expected unknown user to fail")
+	}
+
+	os.WriteFile(path, []byte("carl:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600)
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected reload error: %v", err)
+	}
+	if store.Authenticate("ana", "secret") || !store.Authenticate("carl", "secret") {
+		t.Fatal("expected reload to replace the users")
+	}
+}
+
+func TestLoadHtpasswd_InvalidEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(path, []byte("broken\n"), 0o600)
+	if _, err := LoadHtpasswd(path); err == nil {
+		t.Fatal("expected error for invalid entry")
+	}
+
+	for _, hash := range []string{"$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/", "rqXexS6ZhobKA", "plain"} {
+		os.WriteFile(path, []byte("ana:"+hash+"\n"), 0o600)
+		if _, err := LoadHtpasswd(path); err == nil || !strings.Contains(err.Error(), `"ana"`) {
+			t.Fatalf("expected error for unsupported hash %q, got %v", hash, err)
+		}
+	}
+}
+
+// --- DigestAuth ---
+
+func digestAuthorization(challenge, username, password, method, uri, nc string) string {
+	params := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+	ha1 := md5Hex(username + ":" + params["realm"] + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	response := md5Hex(strings.Join([]string{ha1, params["nonce"], nc, "abc", "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, qop=auth, nc=%s, cnonce="abc", response=%q, opaque=%q`,
+		username, params["realm"], params["nonce"], uri, nc, response, params["opaque"])
+}
+
+func TestDigestAuth(t *testing.T) {
+	server := basicAuthServer()
+	handler := DigestAuth(DigestAuthOptions{Realm: "ops", Users: StaticUsers{"ana": "pw"}})(usernameHandler(), server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	challenge := w.Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, `Digest realm="ops"`) {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.Header.Set("Authorization", digestAuthorization(challenge, "ana", "pw", "GET", "/admin", "00000001"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "ana" {
+		t.Fatalf("expected 200 with username, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Replaying the same nonce count is rejected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 on replay, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/admin", nil)
+	r.Header.Set("Authorization", digestAuthorization(challenge, "ana", "wrong", "GET", "/admin", "00000002"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong password, got %d", w.Code)
+	}
+}
+
+func TestDigestAuth_SweepExpiredCounts(t *testing.T) {
+	now := time.Now()
+	d := &digestAuth{options: DigestAuthOptions{NonceTTL: time.Minute}, counts: map[string]nonceCount{
+		"old":   {count: 3, issued: now.Add(-2 * time.Minute)},
+		"fresh": {count: 1, issued: now.Add(-30 * time.Second)},
+	}}
+	d.sweep(now)
+	if _, ok := d.counts["old"]; ok || len(d.counts) != 1 || !d.lastSweep.Equal(now) {
+		t.Fatalf("expected only the expired count to be removed, got %v", d.counts)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`username="a\"b", qop=auth, nc=00000001, realm="x, y"`)
+	if params["username"] != `a"b` || params["qop"] != "auth" || params["nc"] != "00000001" || params["realm"] != "x, y" {
+		t.Fatalf("unexpected params %v", params)
+	}
+}
@@ -0,0 +1,498 @@
+package nexus
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheContextKey contextKey = "nexus.cache"
+
+// CachedResponse is a complete response kept by the ResponseCache
+type CachedResponse struct {
+	Status               int
+	Header               http.Header
+	Body                 []byte
+	Route                string   // Route is the endpoint pattern, e.g. "GET /products/{id}"
+	Tags                 []string // Tags group entries to invalidate them together
+	StoredAt             time.Time
+	MaxAge               time.Duration
+	StaleWhileRevalidate time.Duration
+}
+
+// CacheStore keeps the cached responses; implementations must be safe for concurrent use
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse)
+	Delete(key string)
+	Keys() []string
+	// DeleteFunc delete the entries that match, without counting the check as a use of the entries
+	DeleteFunc(match func(key string, response *CachedResponse) bool)
+}
+
+// EndpointCache enables the ResponseCache for an endpoint
+type EndpointCache struct {
+	TTL         time.Duration // TTL is used when the response has no Cache-Control max-age
+	QueryParams []string      // QueryParams are part of the key; other parameters are ignored
+	Tags        []string      // Tags are added to every entry of the endpoint
+}
+
+// CacheOptions contains the configuration of the ResponseCache
+type CacheOptions struct {
+	Store       CacheStore // Store defaults to a MemoryCacheStore of MaxEntries
+	MaxEntries  int        // MaxEntries bounds the default store (default 1000)
+	MaxBodySize int        // MaxBodySize is the biggest body that is cached (default 1MB)
+}
+
+// ResponseCache store the GET responses of the endpoints with EndpointOptions.Cache, honouring the
+// Cache-Control max-age, no-store and stale-while-revalidate directives. The responses of requests with
+// credentials, an Authorization header, cookies or the server secret, are only stored when they are
+// Cache-Control public.
+type ResponseCache struct {
+	store       CacheStore
+	maxBodySize int
+	vary        sync.Map // vary keep the Vary header names of every base key
+	refreshing  sync.Map
+	now         func() time.Time
+}
+
+// cacheRequest is the state of the request shared with CacheTags and CacheFromContext
+type cacheRequest struct {
+	cache       *ResponseCache
+	credentials bool // credentials tell that the response may belong to the user of the request
+	mu          sync.Mutex
+	tags        []string
+}
+
+// NewResponseCache create a cache; register its Middleware to use it:
+//
+//	cache := nexus.NewResponseCache(nexus.CacheOptions{MaxEntries: 500})
+//	server.Use(cache.Middleware)
+func NewResponseCache(options CacheOptions) *ResponseCache {
+	if options.MaxEntries == 0 {
+		options.MaxEntries = 1000
+	}
+	if options.MaxBodySize == 0 {
+		options.MaxBodySize = 1 << 20
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryCacheStore(options.MaxEntries)
+	}
+	return &ResponseCache{store: options.Store, maxBodySize: options.MaxBodySize, now: time.Now}
+}
+
+// CacheFromContext return the ResponseCache of the request, so handlers can invalidate entries after changes
+func CacheFromContext(ctx context.Context) (*ResponseCache, bool) {
+	state, ok := ctx.Value(cacheContextKey).(*cacheRequest)
+	if !ok {
+		return nil, false
+	}
+	return state.cache, true
+}
+
+// CacheTags add tags to the response that is being cached, e.g. the id of the returned record
+func CacheTags(r *http.Request, tags ...string) {
+	state, ok := r.Context().Value(cacheContextKey).(*cacheRequest)
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	state.tags = append(state.tags, tags...)
+	state.mu.Unlock()
+}
+
+// InvalidateRoute delete the entries of an endpoint pattern, e.g. "GET /products/{id}"
+func (c *ResponseCache) InvalidateRoute(route string) {
+	c.invalidate(func(entry *CachedResponse) bool {
+		return entry.Route == route
+	})
+}
+
+// InvalidateTag delete the entries that have the tag
+func (c *ResponseCache) InvalidateTag(tag string) {
+	c.invalidate(func(entry *CachedResponse) bool {
+		return contains(entry.Tags, tag)
+	})
+}
+
+// Purge delete every entry
+func (c *ResponseCache) Purge() {
+	c.invalidate(func(*CachedResponse) bool { return true })
+}
+
+func (c *ResponseCache) invalidate(match func(entry *CachedResponse) bool) {
+	c.store.DeleteFunc(func(key string, entry *CachedResponse) bool {
+		return match(entry)
+	})
+}
+
+// Middleware serve the cached responses and store the new ones; it has the signature of Server.Use
+func (c *ResponseCache) Middleware(next http.Handler, server *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &cacheRequest{cache: c}
+		r = r.WithContext(context.WithValue(r.Context(), cacheContextKey, state))
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// HEAD requests are served by the GET endpoint
+		endpoint := server.matchRoute(http.MethodGet + " " + r.URL.Path)
+		if endpoint == nil || endpoint.Options.Cache == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestControl := parseCacheControl(r.Header.Get("Cache-Control"))
+		_, noStore := requestControl["no-store"]
+		if noStore {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, noCache := requestControl["no-cache"]
+
+		state.credentials = r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" || server.requestSecret(r) != ""
+
+		baseKey := cacheBaseKey(r, endpoint.Options.Cache.QueryParams)
+		key := c.key(baseKey, r)
+		if entry, ok := c.store.Get(key); ok && !noCache {
+			age := c.now().Sub(entry.StoredAt)
+			switch {
+			case age < entry.MaxAge:
+				writeCachedResponse(w, r, entry, age, "HIT")
+				return
+			case age < entry.MaxAge+entry.StaleWhileRevalidate:
+				writeCachedResponse(w, r, entry, age, "STALE")
+				c.revalidate(next, r, endpoint, baseKey, key, state.credentials)
+				return
+			}
+		}
+
+		// The headers of the outer middlewares belong to this request, as the CSP nonce
+		rec := &cacheRecorder{ResponseWriter: w, maxBodySize: c.maxBodySize, outer: w.Header().Clone()}
+		w.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(rec, r)
+		if r.Method == http.MethodGet {
+			c.save(rec, r, endpoint, state, baseKey)
+		}
+	})
+}
+
+// revalidate refresh a stale entry in the background; only one refresh per key runs at a time
+func (c *ResponseCache) revalidate(next http.Handler, r *http.Request, endpoint *Endpoint, baseKey string, key string, credentials bool) {
+	if _, running := c.refreshing.LoadOrStore(key, true); running {
+		return
+	}
+
+	state := &cacheRequest{cache: c, credentials: credentials}
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), cacheContextKey, state)
+	refresh := r.Clone(ctx)
+	refresh.Method = http.MethodGet
+	go func() {
+		defer c.refreshing.Delete(key)
+		rec := &cacheRecorder{ResponseWriter: &discardWriter{header: http.Header{}}, maxBodySize: c.maxBodySize}
+		next.ServeHTTP(rec, refresh)
+		c.save(rec, refresh, endpoint, state, baseKey)
+	}()
+}
+
+// save store the recorded response when its status and Cache-Control allow it
+func (c *ResponseCache) save(rec *cacheRecorder, r *http.Request, endpoint *Endpoint, state *cacheRequest, baseKey string) {
+	if rec.code != http.StatusOK || rec.overflow {
+		return
+	}
+	header := rec.Header()
+	if header.Get("Set-Cookie") != "" {
+		return
+	}
+
+	control := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := control["no-store"]; ok {
+		return
+	}
+	if _, ok := control["private"]; ok {
+		return
+	}
+	_, public := control["public"]
+	if state.credentials && !public {
+		return
+	}
+
+	maxAge := endpoint.Options.Cache.TTL
+	if value, ok := control["s-maxage"]; ok {
+		maxAge = parseSeconds(value)
+	} else if value, ok := control["max-age"]; ok {
+		maxAge = parseSeconds(value)
+	}
+	if _, ok := control["no-cache"]; ok {
+		maxAge = 0
+	}
+	staleWhileRevalidate := parseSeconds(control["stale-while-revalidate"])
+	if maxAge <= 0 && staleWhileRevalidate <= 0 {
+		return
+	}
+
+	vary := varyHeaders(header)
+	if len(vary) == 1 && vary[0] == "*" {
+		return
+	}
+	c.vary.Store(baseKey, vary)
+
+	state.mu.Lock()
+	tags := append(append([]string{}, endpoint.Options.Cache.Tags...), state.tags...)
+	state.mu.Unlock()
+
+	stored := http.Header{}
+	for name, values := range header {
+		if perRequestHeader(name) || slices.Equal(values, rec.outer[name]) {
+			continue
+		}
+		stored[name] = append([]string(nil), values...)
+	}
+	c.store.Set(c.key(baseKey, r), &CachedResponse{
+		Status:               rec.code,
+		Header:               stored,
+		Body:                 rec.body.Bytes(),
+		Route:                endpoint.Path,
+		Tags:                 tags,
+		StoredAt:             c.now(),
+		MaxAge:               maxAge,
+		StaleWhileRevalidate: staleWhileRevalidate,
+	})
+}
+
+// key add the values of the Vary headers of the endpoint to the base key
+func (c *ResponseCache) key(baseKey string, r *http.Request) string {
+	vary, ok := c.vary.Load(baseKey)
+	if !ok {
+		return baseKey
+	}
+	key := baseKey
+	for _, name := range vary.([]string) {
+		key += "\n" + name + ":" + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
+}
+
+// cacheBaseKey build the key from the path and the selected query parameters, in a stable order
+func cacheBaseKey(r *http.Request, params []string) string {
+	key := "GET " + r.URL.Path
+	if len(params) == 0 {
+		return key
+	}
+	query := r.URL.Query()
+	selected := url.Values{}
+	for _, param := range params {
+		if values, ok := query[param]; ok {
+			selected[param] = values
+		}
+	}
+	// Encode sorts by name
+	if encoded := selected.Encode(); encoded != "" {
+		key += "?" + encoded
+	}
+	return key
+}
+
+// perRequestHeader evaluate if a header must not be replayed to other clients
+func perRequestHeader(name string) bool {
+	switch name {
+	case "X-Cache", "Age", "Set-Cookie", "Content-Security-Policy", "Content-Security-Policy-Report-Only":
+		return true
+	}
+	return strings.HasPrefix(name, "Access-Control-")
+}
+
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	if contains(names, "*") {
+		return []string{"*"}
+	}
+	return names
+}
+
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, entry *CachedResponse, age time.Duration, status string) {
+	header := w.Header()
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	header.Set("X-Cache", status)
+	w.WriteHeader(entry.Status)
+	if r.Method != http.MethodHead {
+		w.Write(entry.Body)
+	}
+}
+
+// parseCacheControl return the directives of a Cache-Control header with their values
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// cacheRecorder send the response to the client while keeping a copy of it
+type cacheRecorder struct {
+	http.ResponseWriter
+	maxBodySize int
+	outer       http.Header // outer are the headers set before the handler ran
+	code        int
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (w *cacheRecorder) WriteHeader(code int) {
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > w.maxBodySize {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// discardWriter is the client of the background revalidations
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// MemoryCacheStore is a CacheStore that evict the least recently used entries when it is full
+type MemoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+// NewMemoryCacheStore create a store of up to maxEntries responses
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).response, true
+}
+
+func (s *MemoryCacheStore) Set(key string, response *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*memoryCacheEntry).response = response
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&memoryCacheEntry{key: key, response: response})
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+		delete(s.entries, key)
+	}
+}
+
+func (s *MemoryCacheStore) DeleteFunc(match func(key string, response *CachedResponse) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, element := range s.entries {
+		if match(key, element.Value.(*memoryCacheEntry).response) {
+			s.order.Remove(element)
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *MemoryCacheStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len return the number of entries
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
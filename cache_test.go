@@ -0,0 +1,227 @@
+package nexus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheFixture struct {
+	cache   *ResponseCache
+	handler http.Handler
+	calls   atomic.Int32
+	now     time.Time
+}
+
+func newCacheFixture(t *testing.T, cacheControl string) *cacheFixture {
+	t.Helper()
+	f := &cacheFixture{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	f.cache = NewResponseCache(CacheOptions{MaxEntries: 10})
+	f.cache.now = func() time.Time { return f.now }
+
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /products", Options: EndpointOptions{Cache: &EndpointCache{TTL: time.Minute, QueryParams: []string{"page"}, Tags: []string{"products"}}}},
+		{Path: "GET /products/{id}", Options: EndpointOptions{Cache: &EndpointCache{TTL: time.Minute}}},
+		{Path: "GET /live"},
+	})
+
+	f.handler = f.cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := f.calls.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.URL.Path == "/products/1" {
+			CacheTags(r, "product:1")
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s %s %d", r.URL.Path, r.Header.Get("Accept-Language"), n)
+	}), server)
+	return f
+}
+
+func (f *cacheFixture) get(target string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponseCache_HitAndKey(t *testing.T) {
+	f := newCacheFixture(t, "")
+
+	first := f.get("/products?page=1&sort=name")
+	if first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected MISS, got %q", first.Header().Get("X-Cache"))
+	}
+	second := f.get("/products?sort=price&page=1")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Fatalf("expected HIT ignoring unselected params, got %q %q", second.Header().Get("X-Cache"), second.Body.String())
+	}
+
+	if w := f.get("/products?page=2"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected a different entry for another page")
+	}
+	if w := f.get("/products?page=1", "Accept-Language", "es"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected a different entry for another Vary header value")
+	}
+	if w := f.get("/products?page=1", "Cache-Control", "no-cache"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected request no-cache to skip the entry")
+	}
+	if w := f.get("/live"); w.Header().Get("X-Cache") != "" {
+		t.Fatal("expected endpoints without Cache to be skipped")
+	}
+}
+
+func TestResponseCache_MaxAgeAndNoStore(t *testing.T) {
+	f := newCacheFixture(t, "max-age=10")
+	f.get("/products/1")
+	f.now = f.now.Add(5 * time.Second)
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Age") != "5" {
+		t.Fatalf("expected HIT with Age 5, got %q %q", w.Header().Get("X-Cache"), w.Header().Get("Age"))
+	}
+	f.now = f.now.Add(10 * time.Second)
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected the entry to expire after max-age")
+	}
+
+	f = newCacheFixture(t, "no-store")
+	f.get("/products/1")
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected no-store responses not to be cached")
+	}
+}
+
+func TestResponseCache_StaleWhileRevalidate(t *testing.T) {
+	f := newCacheFixture(t, "max-age=10, stale-while-revalidate=30")
+	f.get("/products/1")
+	f.now = f.now.Add(20 * time.Second)
+
+	w := f.get("/products/1")
+	if w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "/products/1  1" {
+		t.Fatalf("expected the stale copy, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for f.calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "/products/1  2" {
+		t.Fatalf("expected the revalidated copy, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+}
+
+func TestResponseCache_Credentials(t *testing.T) {
+	for _, credential := range [][]string{{"Authorization", "Bearer token"}, {"Cookie", "nexus_session=abc"}, {"X-Secret", "secret"}} {
+		f := newCacheFixture(t, "max-age=60")
+		f.get("/products/1", credential...)
+		if w := f.get("/products/1"); w.Header().Get("X-Cache") != "MISS" {
+			t.Fatalf("%s: expected the response of a request with credentials not to be cached", credential[0])
+		}
+	}
+
+	f := newCacheFixture(t, "public, max-age=60")
+	f.get("/products/1", "Cookie", "nexus_session=abc")
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected public responses to be cached")
+	}
+}
+
+func TestResponseCache_Invalidate(t *testing.T) {
+	f := newCacheFixture(t, "")
+	f.get("/products?page=1")
+	f.get("/products/1")
+	f.get("/products/2")
+
+	f.cache.InvalidateTag("product:1")
+	if w := f.get("/products/1"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected the tagged entry to be invalidated")
+	}
+	if w := f.get("/products/2"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected other entries to be kept")
+	}
+
+	f.cache.InvalidateRoute("GET /products/{id}")
+	if w := f.get("/products/2"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected the route entries to be invalidated")
+	}
+	if w := f.get("/products?page=1"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected other routes to be kept")
+	}
+
+	f.cache.InvalidateTag("products")
+	if w := f.get("/products?page=1"); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("expected the endpoint tags to be invalidated")
+	}
+}
+
+func TestResponseCache_OuterHeadersAreNotReplayed(t *testing.T) {
+	f := newCacheFixture(t, "")
+	nonce := 0
+	outer := f.handler
+	f.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce++
+		w.Header().Set("Content-Security-Policy", fmt.Sprintf("script-src 'nonce-%d'", nonce))
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		outer.ServeHTTP(w, r)
+	})
+
+	f.get("/products/1", "Origin", "https://a.example")
+	w := f.get("/products/1", "Origin", "https://b.example")
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Vary") != "Accept-Language" {
+		t.Fatalf("expected a HIT with the handler headers, got %v", w.Header())
+	}
+	if w.Header().Get("Content-Security-Policy") != "script-src 'nonce-2'" || w.Header().Get("Access-Control-Allow-Origin") != "https://b.example" {
+		t.Fatalf("expected the headers of the current request, got %v", w.Header())
+	}
+}
+
+func TestResponseCache_FromContext(t *testing.T) {
+	cache := NewResponseCache(CacheOptions{})
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	var found *ResponseCache
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found, _ = CacheFromContext(r.Context())
+	}), server)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/products/1", nil))
+	if found != cache {
+		t.Fatal("expected the cache in the request context")
+	}
+}
+
+func TestMemoryCacheStore_LRU(t *testing.T) {
+	store := NewMemoryCacheStore(2)
+	store.Set("a", &CachedResponse{})
+	store.Set("b", &CachedResponse{})
+	store.Get("a")
+	store.Set("c", &CachedResponse{})
+
+	if _, ok := store.Get("b"); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	if _, ok := store.Get("a"); !ok {
+		t.Fatal("expected the recently used entry to be kept")
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", store.Len())
+	}
+
+	// Invalidations do not change the order of the kept entries
+	store.Set("d", &CachedResponse{Route: "GET /d"})
+	store.DeleteFunc(func(key string, response *CachedResponse) bool { return key == "x" })
+	store.Set("e", &CachedResponse{})
+	if _, ok := store.Get("d"); !ok {
+		t.Fatal("expected the most recent entry to be kept after an invalidation")
+	}
+	store.DeleteFunc(func(key string, response *CachedResponse) bool { return response.Route == "GET /d" })
+	if _, ok := store.Get("d"); ok || store.Len() != 1 {
+		t.Fatalf("expected the matching entry to be deleted, %d entries", store.Len())
+	}
+}
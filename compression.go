@@ -0,0 +1,355 @@
+package nexus
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionOptions contains the configuration of the Compression middleware
+type CompressionOptions struct {
+	MinSize              int      // MinSize is the smallest body that is compressed (default 1024 bytes)
+	Encodings            []string // Encodings in order of preference for ties (default zstd, gzip, deflate)
+	SkipContentTypes     []string // SkipContentTypes are already compressed types; "image/*" matches a whole family
+	DecompressRequests   bool     // DecompressRequests transparently decodes gzip and deflate request bodies
+	MaxDecompressedBytes int64    // MaxDecompressedBytes limits decoded request bodies (default 10MB)
+}
+
+// defaultSkipContentTypes are formats that are already compressed
+var defaultSkipContentTypes = []string{
+	"image/*", "video/*", "audio/*", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/pdf",
+	"application/octet-stream",
+}
+
+// compressor create an encoder of a content coding over w
+type compressor struct {
+	pool sync.Pool
+	new  func(w io.Writer) io.WriteCloser
+}
+
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressors = map[string]*compressor{
+	"gzip": {new: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	}},
+	"deflate": {new: func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}},
+	"zstd": {new: func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return zw
+	}},
+}
+
+func (c *compressor) get(w io.Writer) resettableWriter {
+	if pooled, ok := c.pool.Get().(resettableWriter); ok {
+		pooled.Reset(w)
+		return pooled
+	}
+	return c.new(w).(resettableWriter)
+}
+
+// Compression return a middleware that compress the responses with the encoding negotiated from Accept-Encoding;
+// endpoints with EndpointOptions.NoCompression are skipped. It panics when Encodings has an encoding other
+// than zstd, gzip and deflate.
+func Compression(options CompressionOptions) func(next http.Handler, server *Server) http.Handler {
+	if options.MinSize == 0 {
+		options.MinSize = 1024
+	}
+	if len(options.Encodings) == 0 {
+		options.Encodings = []string{"zstd", "gzip", "deflate"}
+	}
+	for _, encoding := range options.Encodings {
+		if _, ok := compressors[encoding]; !ok {
+			panic(fmt.Sprintf("nexus: unsupported compression encoding %q", encoding))
+		}
+	}
+	if options.SkipContentTypes == nil {
+		options.SkipContentTypes = defaultSkipContentTypes
+	}
+	if options.MaxDecompressedBytes == 0 {
+		options.MaxDecompressedBytes = 10 << 20
+	}
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if options.DecompressRequests {
+				if err := decompressRequest(w, r, options.MaxDecompressedBytes); err != nil {
+					ResponseWithError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+
+			if endpoint, ok := server.GetEndpoint(r); ok && endpoint.Options.NoCompression {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Upgraded connections are hijacked and never use the response body
+			if r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"), options.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, options: &options, encoding: encoding}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// NegotiateEncoding choose the content coding with the highest q-value of the Accept-Encoding header
+// among the supported ones; ties are resolved by the order of supported. It returns "" for identity.
+func NegotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else if name != "" {
+			qualities[name] = q
+		}
+	}
+
+	type candidate struct {
+		name  string
+		q     float64
+		order int
+	}
+	var candidates []candidate
+	for i, name := range supported {
+		q, ok := qualities[name]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{name, q, i})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].order < candidates[j].order
+	})
+	return candidates[0].name
+}
+
+// decompressRequest replace a gzip or deflate encoded body with its decoded content, limited to protect
+// the server from decompression bombs
+func decompressRequest(w http.ResponseWriter, r *http.Request, limit int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var reader io.ReadCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return errors.New("invalid gzip body")
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(r.Body)
+	default:
+		return nil
+	}
+
+	body := r.Body
+	r.Body = http.MaxBytesReader(w, &decompressedBody{Reader: reader, closers: []io.Closer{reader, body}}, limit)
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+type decompressedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// compressWriter buffer the beginning of the body until it knows whether the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	options  *CompressionOptions
+	encoding string
+
+	code    int
+	buf     []byte
+	decided bool
+	encoder resettableWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	// Informational responses are sent at once and do not count as the final status
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.code = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.options.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide start the compressed or the plain response and write the buffered bytes
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	if large && w.compressible() {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = compressors[w.encoding].get(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.code)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible evaluate the status, the existing encoding and the content type of the response
+func (w *compressWriter) compressible() bool {
+	if w.code < 200 || w.code == http.StatusNoContent || w.code == http.StatusNotModified || w.code == http.StatusPartialContent {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, skip := range w.options.SkipContentTypes {
+		if family, ok := strings.CutSuffix(skip, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return false
+			}
+		} else if mediaType == skip {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush compress what has been written so far and send it; streaming responses are compressed even when small
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.code == 0 && len(w.buf) == 0 {
+			return
+		}
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		compressors[w.encoding].pool.Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
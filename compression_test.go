@@ -0,0 +1,244 @@
+package nexus
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressionHandler(options CompressionOptions, inner http.HandlerFunc) http.Handler {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /data"},
+		{Path: "GET /raw", Options: EndpointOptions{NoCompression: true}},
+		{Path: "POST /upload"},
+	})
+	return Compression(options)(inner, server)
+}
+
+func largeJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`[` + strings.Repeat(`{"name":"nexus"},`, 200) + `{}]`))
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid gzip: %v", err)
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid zstd: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return string(body)
+	}
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	return string(out)
+}
+
+// --- NegotiateEncoding ---
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{"zstd", "gzip", "deflate"}
+	cases := map[string]string{
+		"":                                "",
+		"gzip":                            "gzip",
+		"gzip, deflate, br, zstd":         "zstd",
+		"gzip;q=1.0, zstd;q=0.5":          "gzip",
+		"deflate;q=0.8, gzip;q=0.9":       "gzip",
+		"*":                               "zstd",
+		"*;q=0.1, gzip;q=0":               "zstd",
+		"identity":                        "",
+		"br":                              "",
+		"gzip;q=0, deflate;q=0, zstd;q=0": "",
+	}
+	for header, expected := range cases {
+		if got := NegotiateEncoding(header, supported); got != expected {
+			t.Fatalf("%q: expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+// --- Compression ---
+
+func TestCompression_Encodings(t *testing.T) {
+	handler := compressionHandler(CompressionOptions{}, largeJSON)
+
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/data", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		handler.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("expected %s, got %q", encoding, got)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatal("expected Vary: Accept-Encoding")
+		}
+		if body := decode(t, encoding, w.Body.Bytes()); !strings.HasPrefix(body, `[{"name":"nexus"}`) {
+			t.Fatalf("unexpected body %q", body[:20])
+		}
+	}
+}
+
+func TestCompression_UnsupportedEncoding(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for an encoding without compressor")
+		}
+	}()
+	Compression(CompressionOptions{Encodings: []string{"br", "gzip"}})
+}
+
+func TestCompression_SkipsSmallAndCompressedBodies(t *testing.T) {
+	small := compressionHandler(CompressionOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithJSON(w, http.StatusOK, map[string]string{"ok": "yes"})
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/data", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	small.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != `{"ok":"yes"}` {
+		t.Fatalf("expected small body to be sent as is, got %q", w.Body.String())
+	}
+
+	image := compressionHandler(CompressionOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0}, 4096))
+	})
+	w = httptest.NewRecorder()
+	image.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 4096 {
+		t.Fatal("expected image to be sent uncompressed")
+	}
+}
+
+func TestCompression_NoCompressionEndpoint(t *testing.T) {
+	handler := compressionHandler(CompressionOptions{}, largeJSON)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/raw", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Fatal("expected NoCompression endpoint to be skipped")
+	}
+}
+
+func TestCompression_StreamingFlush(t *testing.T) {
+	flushed := make(chan struct{})
+	handler := compressionHandler(CompressionOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-flushed
+		w.Write([]byte("data: second\n\n"))
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip stream, got %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	first := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(gz, first); err != nil || string(first) != "data: first\n\n" {
+		t.Fatalf("expected the first event before the handler finished, got %q %v", first, err)
+	}
+	close(flushed)
+	rest, _ := io.ReadAll(gz)
+	if string(rest) != "data: second\n\n" {
+		t.Fatalf("unexpected rest %q", rest)
+	}
+}
+
+func TestCompression_WeakensETag(t *testing.T) {
+	handler := compressionHandler(CompressionOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		largeJSON(w, r)
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/data", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+	if etag := w.Header().Get("ETag"); etag != `W/"abc"` {
+		t.Fatalf("expected weak ETag, got %q", etag)
+	}
+}
+
+func TestCompression_DecompressRequests(t *testing.T) {
+	var received string
+	handler := compressionHandler(CompressionOptions{DecompressRequests: true, MaxDecompressedBytes: 64}, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		received = string(body)
+	})
+
+	gzipped := func(s string) *bytes.Buffer {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return &buf
+	}
+
+	r := httptest.NewRequest("POST", "/upload", gzipped(`{"a":1}`))
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if received != `{"a":1}` {
+		t.Fatalf("expected decompressed body, got %q", received)
+	}
+
+	r = httptest.NewRequest("POST", "/upload", gzipped(strings.Repeat("a", 1000)))
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected decompression limit, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/upload", strings.NewReader("not gzip"))
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid gzip, got %d", w.Code)
+	}
+}
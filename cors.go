@@ -0,0 +1,110 @@
+package nexus
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/cors"
+)
+
+// corsPolicies cache the cors.Cors built for every endpoint, since building them on each request is expensive
+type corsPolicies struct {
+	server   *Server
+	fallback *cors.Cors
+	cache    sync.Map
+}
+
+// CorsHandler apply the CORS policy of the endpoint that serves the request, falling back to Server.CorsOptions.
+// Preflight requests only allow the methods registered for the path.
+func (server *Server) CorsHandler(next http.Handler) http.Handler {
+	policies := &corsPolicies{server: server, fallback: cors.New(server.CorsOptions)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policies.policy(r).Handler(next).ServeHTTP(w, r)
+	})
+}
+
+// policy return the cors.Cors of the request
+func (p *corsPolicies) policy(r *http.Request) *cors.Cors {
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !preflight {
+		endpoint, ok := p.server.GetEndpoint(r)
+		if !ok || endpoint.Options.Cors == nil {
+			return p.fallback
+		}
+		return p.cached("request "+endpoint.Path, func() cors.Options { return *endpoint.Options.Cors })
+	}
+
+	endpoints := p.server.pathEndpoints(r.URL.Path)
+	if len(endpoints) == 0 {
+		return p.fallback
+	}
+
+	// The policy of the endpoint of the requested method decides; the methods of the whole path are announced
+	requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	selected := endpoints[0]
+	var methods []string
+	for _, endpoint := range endpoints {
+		method, _, _ := strings.Cut(endpoint.Path, " ")
+		methods = append(methods, method)
+		if method == requested {
+			selected = endpoint
+		}
+	}
+	sort.Strings(methods)
+
+	key := "preflight " + selected.Path + " " + strings.Join(methods, ",")
+	return p.cached(key, func() cors.Options {
+		options := p.server.CorsOptions
+		if selected.Options.Cors != nil {
+			options = *selected.Options.Cors
+		}
+		options.AllowedMethods = allowedMethods(options.AllowedMethods, methods)
+		return options
+	})
+}
+
+func (p *corsPolicies) cached(key string, options func() cors.Options) *cors.Cors {
+	if c, ok := p.cache.Load(key); ok {
+		return c.(*cors.Cors)
+	}
+	c, _ := p.cache.LoadOrStore(key, cors.New(options()))
+	return c.(*cors.Cors)
+}
+
+// allowedMethods restrict the methods of the policy to the registered ones; a policy without methods allows all of them
+func allowedMethods(policy []string, registered []string) []string {
+	if len(policy) == 0 {
+		return registered
+	}
+	var methods []string
+	for _, method := range registered {
+		for _, allowed := range policy {
+			if allowed == "*" || strings.EqualFold(allowed, method) {
+				methods = append(methods, method)
+				break
+			}
+		}
+	}
+	if len(methods) == 0 {
+		// An empty list makes rs/cors use its defaults, so an impossible method is used instead
+		return []string{"-"}
+	}
+	return methods
+}
+
+// pathEndpoints return the endpoints of every method that match the path, sorted by pattern
+func (server *Server) pathEndpoints(path string) []*Endpoint {
+	var endpoints []*Endpoint
+	for _, endpoint := range server.EndpointsPaths {
+		method, _, ok := strings.Cut(endpoint.Path, " ")
+		if ok && endpoint.RegexPattern != nil && endpoint.RegexPattern.MatchString(method+" "+path) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	// The map order is random; the first endpoint decides the preflights of the methods that are not registered
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Path < endpoints[j].Path })
+	return endpoints
+}
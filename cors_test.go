@@ -0,0 +1,129 @@
+package nexus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/cors"
+)
+
+func corsTestServer() http.Handler {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	server := &Server{
+		CorsOptions: cors.Options{
+			AllowedOrigins:   []string{"https://admin.example.com"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+			AllowCredentials: true,
+		},
+		Endpoints: [][]Endpoint{
+			{
+				{Path: "GET /widget", HandlerFunc: ok, Options: EndpointOptions{Cors: &cors.Options{AllowedOrigins: []string{"*"}}}},
+				{Path: "GET /items", HandlerFunc: ok},
+				{Path: "POST /items", HandlerFunc: ok},
+			},
+		},
+	}
+	server.GroupWithOptions("/public", []Endpoint{
+		{Path: "GET /feed", HandlerFunc: ok},
+	}, &GroupOptions{Cors: &cors.Options{AllowedOrigins: []string{"https://partner.test"}}})
+	return server.Handler()
+}
+
+func corsRequest(handler http.Handler, method, path, origin, requestMethod string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Origin", origin)
+	if requestMethod != "" {
+		r.Header.Set("Access-Control-Request-Method", requestMethod)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// --- CorsHandler ---
+
+func TestCorsHandler_EndpointOverride(t *testing.T) {
+	handler := corsTestServer()
+
+	w := corsRequest(handler, "GET", "/widget", "https://anyone.test", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected the widget to be open to *, got %q", got)
+	}
+
+	w = corsRequest(handler, "GET", "/items", "https://anyone.test", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected the server policy to reject the origin, got %q", got)
+	}
+
+	w = corsRequest(handler, "GET", "/items", "https://admin.example.com", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://admin.example.com" {
+		t.Fatalf("expected the admin origin to be allowed, got %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("expected credentials to be allowed by the server policy")
+	}
+}
+
+func TestCorsHandler_PreflightRegisteredMethods(t *testing.T) {
+	handler := corsTestServer()
+
+	w := corsRequest(handler, "OPTIONS", "/items", "https://admin.example.com", "POST")
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "POST" {
+		t.Fatalf("expected POST to be allowed, got %q", got)
+	}
+
+	// DELETE is allowed by the server policy but not registered for /items
+	w = corsRequest(handler, "OPTIONS", "/items", "https://admin.example.com", "DELETE")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected DELETE preflight to be rejected, got %q", got)
+	}
+}
+
+func TestCorsHandler_PreflightEndpointPolicy(t *testing.T) {
+	handler := corsTestServer()
+
+	w := corsRequest(handler, "OPTIONS", "/widget", "https://anyone.test", "GET")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected the widget preflight to use its own policy, got %q", got)
+	}
+}
+
+func TestPathEndpoints_Sorted(t *testing.T) {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "PUT /docs/{id}"},
+		{Path: "GET /docs/{id}"},
+		{Path: "DELETE /docs/{id}"},
+		{Path: "PATCH /docs/{id}"},
+	})
+
+	// The preflights of other methods use the first endpoint, whatever the map order
+	for i := 0; i < 20; i++ {
+		var paths []string
+		for _, endpoint := range server.pathEndpoints("/docs/7") {
+			paths = append(paths, endpoint.Path)
+		}
+		if len(paths) != 4 || paths[0] != "DELETE /docs/{id}" || paths[3] != "PUT /docs/{id}" {
+			t.Fatalf("unexpected endpoints %v", paths)
+		}
+	}
+}
+
+func TestCorsHandler_GroupPolicy(t *testing.T) {
+	handler := corsTestServer()
+
+	w := corsRequest(handler, "GET", "/public/feed", "https://partner.test", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://partner.test" {
+		t.Fatalf("expected the group policy, got %q", got)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	if methods := allowedMethods(nil, []string{"GET", "POST"}); len(methods) != 2 {
+		t.Fatalf("expected all registered methods, got %v", methods)
+	}
+	if methods := allowedMethods([]string{"get"}, []string{"GET", "POST"}); len(methods) != 1 || methods[0] != "GET" {
+		t.Fatalf("expected GET only, got %v", methods)
+	}
+}
@@ -0,0 +1,216 @@
+package nexus
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const csrfContextKey contextKey = "nexus.csrf"
+
+var ErrCSRFNoSession = errors.New("CSRF synchronizer mode requires the Sessions middleware")
+
+// csrfSessionKey is the session value that keeps the synchronizer token
+const csrfSessionKey = "_csrf"
+
+// CSRFMode is the strategy used to keep the expected token
+type CSRFMode int
+
+const (
+	// CSRFSynchronizer keep the token in the session; the Sessions middleware must run before CSRF
+	CSRFSynchronizer CSRFMode = iota
+	// CSRFDoubleSubmit keep the token in a cookie readable by the page scripts, which send it back in a header
+	CSRFDoubleSubmit
+)
+
+// CSRFOptions contains the configuration of the CSRF middleware
+type CSRFOptions struct {
+	Mode           CSRFMode
+	HeaderName     string   // HeaderName carries the token in requests (default X-CSRF-Token)
+	FormField      string   // FormField carries the token in url encoded form posts (default csrf_token)
+	CookieName     string   // CookieName is the double submit cookie (default nexus_csrf)
+	Secret         []byte   // Secret signs double submit tokens so they cannot be planted by a sibling domain
+	TrustedOrigins []string // TrustedOrigins are allowed besides the request origin and CorsOptions.AllowedOrigins
+}
+
+// CSRFToken return the token of the request, to be embedded in forms or sent in the X-CSRF-Token header
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey).(string)
+	return token
+}
+
+// CSRF return a middleware that reject state-changing requests without a valid token or coming from an
+// untrusted origin; endpoints with EndpointOptions.CSRFExempt are skipped
+func CSRF(options CSRFOptions) func(next http.Handler, server *Server) http.Handler {
+	if options.HeaderName == "" {
+		options.HeaderName = "X-CSRF-Token"
+	}
+	if options.FormField == "" {
+		options.FormField = "csrf_token"
+	}
+	if options.CookieName == "" {
+		options.CookieName = "nexus_csrf"
+	}
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := options.token(w, r)
+			if err != nil {
+				ResponseWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), csrfContextKey, token))
+
+			if isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if endpoint, ok := server.GetEndpoint(r); ok && endpoint.Options.CSRFExempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if reason := options.check(server, r, token); reason != "" {
+				ResponseJsonWithError(w, http.StatusForbidden, &ErrorResponse{
+					Code:     http.StatusForbidden,
+					Message:  "CSRF validation failed",
+					CodeName: "csrf_failed",
+					Errors:   map[string]string{"csrf": reason},
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// token return the expected token, issuing a new one when the client has none
+func (options *CSRFOptions) token(w http.ResponseWriter, r *http.Request) (string, error) {
+	if options.Mode == CSRFSynchronizer {
+		session := GetSession(r)
+		if session == nil {
+			return "", ErrCSRFNoSession
+		}
+		if token, ok := SessionValue[string](session, csrfSessionKey); ok && token != "" {
+			return token, nil
+		}
+		token := options.newToken()
+		return token, session.Set(csrfSessionKey, token)
+	}
+
+	if cookie, err := r.Cookie(options.CookieName); err == nil && options.validSigned(cookie.Value) {
+		return cookie.Value, nil
+	}
+	token := options.newToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     options.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   RequestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// newToken return a random token, signed when a secret is configured
+func (options *CSRFOptions) newToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if len(options.Secret) > 0 {
+		token += "." + options.signature(token)
+	}
+	return token
+}
+
+func (options *CSRFOptions) signature(value string) string {
+	mac := hmac.New(sha256.New, options.Secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (options *CSRFOptions) validSigned(token string) bool {
+	if len(options.Secret) == 0 {
+		return token != ""
+	}
+	value, signature, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(signature), []byte(options.signature(value)))
+}
+
+// check return the reason the request fails the CSRF defences, or an empty string
+func (options *CSRFOptions) check(server *Server, r *http.Request, expected string) string {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" && !options.trustedOrigin(server, r, r.Header.Get("Origin")) {
+		return "cross-site request"
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		if referer, err := url.Parse(r.Referer()); err == nil && referer.Host != "" {
+			origin = referer.Scheme + "://" + referer.Host
+		}
+	}
+	if origin != "" && !options.trustedOrigin(server, r, origin) {
+		return "untrusted origin"
+	}
+
+	given := r.Header.Get(options.HeaderName)
+	if given == "" {
+		// Only the url encoded forms are read, a multipart body would be buffered before the handler limits it
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			given = r.PostFormValue(options.FormField)
+		}
+	}
+	if given == "" {
+		return "missing token"
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+		return "invalid token"
+	}
+	return ""
+}
+
+// trustedOrigin evaluate if the origin is the server itself, a TrustedOrigin or one of the CorsOptions.AllowedOrigins;
+// the "*" wildcard of CORS is never trusted for CSRF
+func (options *CSRFOptions) trustedOrigin(server *Server, r *http.Request, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if strings.EqualFold(origin, RequestScheme(r)+"://"+r.Host) {
+		return true
+	}
+	allowed := append(append([]string{}, options.TrustedOrigins...), server.CorsOptions.AllowedOrigins...)
+	for _, pattern := range allowed {
+		if pattern != "*" && matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin compare an origin with a pattern that may contain one "*", as in https://*.example.com
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/cors"
+)
+
+func csrfServer() *Server {
+	server := &Server{
+		EndpointsPaths: make(map[string]*Endpoint),
+		CorsOptions:    cors.Options{AllowedOrigins: []string{"https://app.example.com", "https://*.partner.com", "*"}},
+	}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /form"},
+		{Path: "POST /form"},
+		{Path: "POST /webhook", Options: EndpointOptions{CSRFExempt: true}},
+	})
+	return server
+}
+
+func csrfHandler(options CSRFOptions, server *Server) http.Handler {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	})
+	return CSRF(options)(inner, server)
+}
+
+// --- CSRF double submit ---
+
+func TestCSRF_DoubleSubmit(t *testing.T) {
+	server := csrfServer()
+	handler := csrfHandler(CSRFOptions{Mode: CSRFDoubleSubmit, Secret: []byte("k")}, server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != w.Body.String() {
+		t.Fatalf("expected the token cookie to match the context token, got %v", cookies)
+	}
+	token := cookies[0]
+
+	// Valid token in the header
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/form", nil)
+	r.AddCookie(token)
+	r.Header.Set("X-CSRF-Token", token.Value)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Valid token in the form
+	w = httptest.NewRecorder()
+	form := url.Values{"csrf_token": {token.Value}}
+	r = httptest.NewRequest("POST", "/form", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(token)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with form token, got %d", w.Code)
+	}
+
+	// A multipart body is not read for the token
+	w = httptest.NewRecorder()
+	body := "--b\r\nContent-Disposition: form-data; name=\"csrf_token\"\r\n\r\n" + token.Value + "\r\n--b--\r\n"
+	r = httptest.NewRequest("POST", "/form", strings.NewReader(body))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	r.AddCookie(token)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || r.MultipartForm != nil {
+		t.Fatalf("expected 403 without parsing the multipart body, got %d", w.Code)
+	}
+
+	// Missing token
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/form", nil)
+	r.AddCookie(token)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.CodeName != "csrf_failed" || resp.Errors["csrf"] != "missing token" {
+		t.Fatalf("unexpected error response %+v", resp)
+	}
+}
+
+func TestCSRF_UnsignedCookieIsReplaced(t *testing.T) {
+	handler := csrfHandler(CSRFOptions{Mode: CSRFDoubleSubmit, Secret: []byte("k")}, csrfServer())
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/form", nil)
+	r.AddCookie(&http.Cookie{Name: "nexus_csrf", Value: "planted"})
+	r.Header.Set("X-CSRF-Token", "planted")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a planted cookie, got %d", w.Code)
+	}
+}
+
+func TestCSRF_Origins(t *testing.T) {
+	server := csrfServer()
+	handler := csrfHandler(CSRFOptions{Mode: CSRFDoubleSubmit}, server)
+
+	cases := []struct {
+		origin   string
+		fetch    string
+		expected int
+	}{
+		{"https://app.example.com", "", http.StatusOK},
+		{"https://shop.partner.com", "cross-site", http.StatusOK},
+		{"http://example.com", "", http.StatusOK}, // same origin as the request host
+		{"https://evil.com", "", http.StatusForbidden},
+		{"", "cross-site", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/form", nil)
+		r.AddCookie(&http.Cookie{Name: "nexus_csrf", Value: "tok"})
+		r.Header.Set("X-CSRF-Token", "tok")
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.fetch != "" {
+			r.Header.Set("Sec-Fetch-Site", c.fetch)
+		}
+		handler.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Fatalf("origin %q: expected %d, got %d", c.origin, c.expected, w.Code)
+		}
+	}
+}
+
+func TestCSRF_Exempt(t *testing.T) {
+	handler := csrfHandler(CSRFOptions{Mode: CSRFDoubleSubmit}, csrfServer())
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/webhook", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for exempt endpoint, got %d", w.Code)
+	}
+}
+
+// --- CSRF synchronizer ---
+
+func TestCSRF_Synchronizer(t *testing.T) {
+	server := csrfServer()
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	})
+	handler := Sessions(SessionOptions{Secret: []byte("s")})(CSRF(CSRFOptions{})(inner, server), server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	token := w.Body.String()
+	session := w.Result().Cookies()[0]
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/form", nil)
+	r.AddCookie(session)
+	r.Header.Set("X-CSRF-Token", token)
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/form", nil)
+	r.AddCookie(session)
+	r.Header.Set("X-CSRF-Token", "other")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestCSRF_SynchronizerWithoutSessions(t *testing.T) {
+	handler := csrfHandler(CSRFOptions{}, csrfServer())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	if !matchOrigin("https://*.example.com", "https://a.example.com") {
+		t.Fatal("expected wildcard match")
+	}
+	if matchOrigin("https://*.example.com", "https://example.com.evil.io") {
+		t.Fatal("expected wildcard mismatch")
+	}
+}
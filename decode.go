@@ -0,0 +1,180 @@
+package nexus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const maxBodyBytesContextKey contextKey = "nexus.max_body_bytes"
+
+// DefaultMaxBodyBytes is the body limit of DecodeJSON when neither the endpoint nor the options set one
+const DefaultMaxBodyBytes = 1 << 20
+
+// DecodeOptions contains the configuration of DecodeJSON
+type DecodeOptions struct {
+	MaxBytes              int64 // MaxBytes overrides EndpointOptions.MaxBodyBytes and DefaultMaxBodyBytes
+	DisallowUnknownFields bool  // DisallowUnknownFields rejects members that are not in the destination
+	DisallowTrailingData  bool  // DisallowTrailingData rejects anything after the JSON value
+	AllowEmptyBody        bool  // AllowEmptyBody leaves the destination untouched instead of failing with body_required
+	RequireContentType    bool  // RequireContentType rejects requests without Content-Type; a wrong type is always rejected
+}
+
+// DecodeJSON decode the JSON body of the request into v; the returned *Error has a precise code
+// (body_required, body_too_large, unsupported_media_type, invalid_json, invalid_field_type, unknown_field,
+// trailing_data) and can be answered with ResponseFromError:
+//
+//	var in CreateUserReq
+//	if err := nexus.DecodeJSON(r, &in, nexus.DecodeOptions{DisallowUnknownFields: true}); err != nil {
+//		nexus.ResponseFromError(w, err)
+//		return
+//	}
+func DecodeJSON(r *http.Request, v any, options DecodeOptions) error {
+	if err := checkJSONContentType(r, options.RequireContentType); err != nil {
+		return err
+	}
+
+	limit := options.MaxBytes
+	if limit == 0 {
+		limit, _ = r.Context().Value(maxBodyBytesContextKey).(int64)
+	}
+	if limit == 0 {
+		limit = DefaultMaxBodyBytes
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return emptyBodyError(options)
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, limit))
+	if options.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return emptyBodyError(options)
+		}
+		return decodeError(err)
+	}
+
+	if options.DisallowTrailingData {
+		if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return decodeError(err)
+			}
+			return decodingError(http.StatusBadRequest, "trailing_data", "body", "the body must contain a single JSON value")
+		}
+	}
+	return nil
+}
+
+// checkJSONContentType accept application/json and the +json media types
+func checkJSONContentType(r *http.Request, required bool) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		if required {
+			return decodingError(http.StatusUnsupportedMediaType, "unsupported_media_type", "content_type", "Content-Type must be application/json")
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return decodingError(http.StatusUnsupportedMediaType, "unsupported_media_type", "content_type", "Content-Type must be application/json")
+	}
+	return nil
+}
+
+func emptyBodyError(options DecodeOptions) error {
+	if options.AllowEmptyBody {
+		return nil
+	}
+	return decodingError(http.StatusBadRequest, "body_required", "body", "the request body is required")
+}
+
+// decodeError translate the errors of encoding/json into the codes of DecodeJSON
+func decodeError(err error) *Error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return decodingError(http.StatusRequestEntityTooLarge, "body_too_large", "body",
+			fmt.Sprintf("the body must not be larger than %d bytes", maxBytesError.Limit))
+	case errors.As(err, &syntaxError):
+		return decodingError(http.StatusBadRequest, "invalid_json", "body",
+			fmt.Sprintf("invalid JSON at offset %d", syntaxError.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return decodingError(http.StatusBadRequest, "invalid_json", "body", "the JSON body is incomplete")
+	case errors.As(err, &typeError):
+		field := validationPath(typeError.Field)
+		if field == "" {
+			field = "body"
+		}
+		return decodingError(http.StatusBadRequest, "invalid_field_type", field,
+			fmt.Sprintf("must be %s, got %s", jsonTypeName(typeError.Type), typeError.Value))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return decodingError(http.StatusBadRequest, "unknown_field", field, "is not allowed")
+	}
+	return decodingError(http.StatusBadRequest, "invalid_json", "body", err.Error())
+}
+
+// jsonTypeName describe a Go type with the JSON type that it accepts
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// validationPath write the dotted path of encoding/json like the paths of Validate, e.g. items[0].price
+func validationPath(field string) string {
+	var path string
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil && path != "" {
+			path += "[" + part + "]"
+		} else {
+			path = joinPath(path, part)
+		}
+	}
+	return path
+}
+
+func decodingError(status int, codeName, field, msg string) *Error {
+	err := NewError(status, msg)
+	err.CodeName = codeName
+	err.Fields = map[string]string{field: msg}
+	return err
+}
+
+// limitBody apply EndpointOptions.MaxBodyBytes to the body and keep the limit for DecodeJSON
+func limitBody(limit int64, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), maxBodyBytesContextKey, limit)))
+	})
+}
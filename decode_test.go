@@ -0,0 +1,111 @@
+package nexus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type decodeTarget struct {
+	Name  string `json:"name"`
+	Age   int    `json:"age"`
+	Items []struct {
+		Price float64 `json:"price"`
+	} `json:"items"`
+}
+
+func decodeRequest(body, contentType string) *http.Request {
+	r := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func expectDecodeError(t *testing.T, err error, status int, codeName, field string) {
+	t.Helper()
+	var typed *Error
+	if !errors.As(err, &typed) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if typed.Status != status || typed.CodeName != codeName {
+		t.Fatalf("expected %d %s, got %d %s (%s)", status, codeName, typed.Status, typed.CodeName, typed.Message)
+	}
+	if _, ok := typed.Fields[field]; !ok {
+		t.Fatalf("expected the %s field, got %v", field, typed.Fields)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var v decodeTarget
+	err := DecodeJSON(decodeRequest(`{"name":"Ada","age":36}`, "application/json; charset=utf-8"), &v, DecodeOptions{})
+	if err != nil || v.Name != "Ada" || v.Age != 36 {
+		t.Fatalf("unexpected result %+v %v", v, err)
+	}
+	if err := DecodeJSON(decodeRequest(`{}`, "application/merge-patch+json"), &v, DecodeOptions{}); err != nil {
+		t.Fatalf("expected +json types to be accepted, got %v", err)
+	}
+}
+
+func TestDecodeJSON_Errors(t *testing.T) {
+	cases := []struct {
+		name        string
+		body        string
+		contentType string
+		options     DecodeOptions
+		status      int
+		codeName    string
+		field       string
+	}{
+		{"empty", "", "application/json", DecodeOptions{}, 400, "body_required", "body"},
+		{"content type", `{}`, "text/plain", DecodeOptions{}, 415, "unsupported_media_type", "content_type"},
+		{"missing content type", `{}`, "", DecodeOptions{RequireContentType: true}, 415, "unsupported_media_type", "content_type"},
+		{"syntax", `{"name":}`, "application/json", DecodeOptions{}, 400, "invalid_json", "body"},
+		{"incomplete", `{"name":"Ada"`, "application/json", DecodeOptions{}, 400, "invalid_json", "body"},
+		{"type", `{"age":"old"}`, "application/json", DecodeOptions{}, 400, "invalid_field_type", "age"},
+		{"nested type", `{"items":[{"price":"free"}]}`, "application/json", DecodeOptions{}, 400, "invalid_field_type", "items[0].price"},
+		{"unknown", `{"nickname":"a"}`, "application/json", DecodeOptions{DisallowUnknownFields: true}, 400, "unknown_field", "nickname"},
+		{"trailing", `{"name":"a"} {"name":"b"}`, "application/json", DecodeOptions{DisallowTrailingData: true}, 400, "trailing_data", "body"},
+		{"too large", `{"name":"` + strings.Repeat("a", 100) + `"}`, "application/json", DecodeOptions{MaxBytes: 32}, 413, "body_too_large", "body"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var v decodeTarget
+			err := DecodeJSON(decodeRequest(c.body, c.contentType), &v, c.options)
+			expectDecodeError(t, err, c.status, c.codeName, c.field)
+		})
+	}
+
+	var v decodeTarget
+	if err := DecodeJSON(decodeRequest("", "application/json"), &v, DecodeOptions{AllowEmptyBody: true}); err != nil {
+		t.Fatalf("expected empty body to be allowed, got %v", err)
+	}
+}
+
+func TestEndpointMaxBodyBytes(t *testing.T) {
+	server := &Server{ServerName: "Decode"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "POST /small", Options: EndpointOptions{MaxBodyBytes: 16}, ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request) error {
+			var v decodeTarget
+			if err := DecodeJSON(r, &v, DecodeOptions{}); err != nil {
+				return err
+			}
+			return ResponseWithJSON(w, http.StatusOK, v)
+		}},
+	})
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/small", strings.NewReader(`{"name":"Ada"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/small", strings.NewReader(`{"name":"Ada Lovelace"}`)))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "body_too_large") {
+		t.Fatalf("expected 413, got %d %s", w.Code, w.Body.String())
+	}
+}
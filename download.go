@@ -0,0 +1,164 @@
+package nexus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentDisposition build an RFC 6266 Content-Disposition header; non ASCII names are sent in filename*
+// with an ASCII fallback in filename for the old clients
+func ContentDisposition(disposition string, filename string) string {
+	filename = sanitizeFilename(strings.ToValidUTF8(filename, "_"))
+	if filename == "" {
+		return disposition
+	}
+
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			fallback.WriteRune('_')
+		case r >= utf8.RuneSelf:
+			ascii = false
+			fallback.WriteRune('_')
+		case r == '"' || r == '\\':
+			fallback.WriteRune('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	header := disposition + `; filename="` + fallback.String() + `"`
+	if !ascii {
+		header += "; filename*=UTF-8''" + extValue(filename)
+	}
+	return header
+}
+
+// extValue percent-encode everything but the attr-char of RFC 8187
+func extValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// ServeDownload send content as an attachment named name. It answers single and multiple ranges, If-Range
+// and the conditional requests with http.ServeContent; the ETag header, when set before the call, is used
+// as validator. Unsatisfiable ranges and failed preconditions are answered like the other errors of nexus.
+//
+//	report, _ := os.Open(path)
+//	defer report.Close()
+//	info, _ := report.Stat()
+//	return nexus.ServeDownload(w, r, "résumé 2026.pdf", report, info.ModTime())
+func ServeDownload(w http.ResponseWriter, r *http.Request, name string, content io.ReadSeeker, modtime time.Time) error {
+	if content == nil {
+		return errors.New("nexus: ServeDownload needs content")
+	}
+	setDownloadHeaders(w, name, "")
+	http.ServeContent(&downloadWriter{ResponseWriter: w}, r, name, modtime, content)
+	return nil
+}
+
+// StreamDownload send generated content of unknown length as an attachment, without ranges. An error of the
+// first read is returned before anything is sent, so it can be answered with ResponseFromError; a later
+// error aborts the connection, so the client does not take the truncated file as complete.
+func StreamDownload(w http.ResponseWriter, r *http.Request, name string, contentType string, content io.Reader) error {
+	buf := make([]byte, 32<<10)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	complete := err != nil
+
+	setDownloadHeaders(w, name, contentType)
+	header := w.Header()
+	header.Set("Accept-Ranges", "none")
+	if complete {
+		// The whole content fits in the first read
+		header.Set("Content-Length", strconv.Itoa(n))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	if _, err := w.Write(buf[:n]); err != nil || complete {
+		return err
+	}
+	if _, err := io.CopyBuffer(w, content, buf); err != nil {
+		if r.Context().Err() != nil {
+			// The client is gone
+			return err
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+func setDownloadHeaders(w http.ResponseWriter, name string, contentType string) {
+	header := w.Header()
+	header.Set("Content-Disposition", ContentDisposition("attachment", name))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if contentType == "" {
+		contentType = header.Get("Content-Type")
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+}
+
+// downloadWriter replace the plain text errors of http.ServeContent with the errors of nexus
+type downloadWriter struct {
+	http.ResponseWriter
+	failed bool
+}
+
+func (w *downloadWriter) WriteHeader(code int) {
+	var msg string
+	switch code {
+	case http.StatusRequestedRangeNotSatisfiable:
+		msg = "the requested range is not satisfiable"
+	case http.StatusPreconditionFailed:
+		msg = "the file does not match the preconditions"
+	default:
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.failed = true
+	header := w.Header()
+	header.Del("Content-Disposition")
+	header.Del("Content-Length")
+	ResponseWithError(w.ResponseWriter, code, msg)
+}
+
+func (w *downloadWriter) Write(b []byte) (int, error) {
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *downloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,159 @@
+package nexus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.csv", `attachment; filename="report.csv"`},
+		{"résumé 2026.pdf", `attachment; filename="r_sum_ 2026.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%202026.pdf`},
+		{`say "hi".txt`, `attachment; filename="say \"hi\".txt"`},
+		{"../../etc/passwd", `attachment; filename="passwd"`},
+		{"", "attachment"},
+	}
+	for _, tt := range tests {
+		if got := ContentDisposition("attachment", tt.name); got != tt.want {
+			t.Errorf("ContentDisposition(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func downloadRequest(header map[string]string) (*httptest.ResponseRecorder, *http.Request) {
+	r := httptest.NewRequest("GET", "/reports/1", nil)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	return httptest.NewRecorder(), r
+}
+
+func TestServeDownload(t *testing.T) {
+	content := "0123456789abcdefghij"
+	modtime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	serve := func(header map[string]string) *httptest.ResponseRecorder {
+		w, r := downloadRequest(header)
+		w.Header().Set("ETag", `"v1"`)
+		if err := ServeDownload(w, r, "report.csv", strings.NewReader(content), modtime); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := serve(nil)
+	if w.Code != http.StatusOK || w.Body.String() != content {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Disposition") != `attachment; filename="report.csv"` || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("headers = %v", w.Header())
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("headers = %v", w.Header())
+	}
+
+	w = serve(map[string]string{"Range": "bytes=10-14"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "abcde" || w.Header().Get("Content-Range") != "bytes 10-14/20" {
+		t.Errorf("single range: status = %d, body = %q, Content-Range = %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+
+	w = serve(map[string]string{"Range": "bytes=0-1,18-"})
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("multiple ranges: status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "01") || !strings.Contains(w.Body.String(), "ij") {
+		t.Errorf("multiple ranges body = %q", w.Body.String())
+	}
+
+	// A changed file is sent whole instead of the range of the old version
+	w = serve(map[string]string{"Range": "bytes=10-14", "If-Range": `"v0"`})
+	if w.Code != http.StatusOK || w.Body.String() != content {
+		t.Errorf("If-Range mismatch: status = %d", w.Code)
+	}
+	w = serve(map[string]string{"Range": "bytes=10-14", "If-Range": `"v1"`})
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("If-Range match: status = %d", w.Code)
+	}
+
+	w = serve(map[string]string{"Range": "bytes=50-60"})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */20" {
+		t.Errorf("unsatisfiable: status = %d, Content-Range = %q", w.Code, w.Header().Get("Content-Range"))
+	}
+	if !strings.Contains(w.Body.String(), `"code_name":"requested_range_not_satisfiable"`) || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("unsatisfiable body = %q, headers = %v", w.Body.String(), w.Header())
+	}
+
+	w = serve(map[string]string{"If-Match": `"v0"`})
+	if w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "precondition_failed") {
+		t.Errorf("If-Match: status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestStreamDownload(t *testing.T) {
+	w, r := downloadRequest(nil)
+	if err := StreamDownload(w, r, "small.txt", "", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "hello" || w.Header().Get("Content-Length") != "5" || w.Header().Get("Accept-Ranges") != "none" {
+		t.Errorf("small: body = %q, headers = %v", w.Body.String(), w.Header())
+	}
+
+	large := bytes.Repeat([]byte("x"), 100<<10)
+	w, r = downloadRequest(map[string]string{"Range": "bytes=0-9"})
+	if err := StreamDownload(w, r, "export.ndjson", "application/x-ndjson", bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != len(large) || w.Header().Get("Content-Length") != "" {
+		t.Errorf("large: status = %d, length = %d, headers = %v", w.Code, w.Body.Len(), w.Header())
+	}
+	if w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+
+	w, r = downloadRequest(nil)
+	failure := errors.New("report generation failed")
+	if err := StreamDownload(w, r, "broken.csv", "", &failingReader{err: failure}); !errors.Is(err, failure) {
+		t.Errorf("first read error = %v", err)
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Content-Disposition") != "" {
+		t.Error("nothing must be sent when the first read fails")
+	}
+}
+
+func TestStreamDownload_AbortsTruncatedTransfers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamDownload(w, r, "export.csv", "", &failingReader{data: bytes.Repeat([]byte("x"), 64<<10), err: errors.New("lost")})
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("the client must see the transfer fail")
+	}
+}
@@ -67,6 +67,10 @@ func convertToRegex(pattern string) *regexp.Regexp {
 
 	// Reemplazar {param} por regex y almacenar nombres de parámetros
 	regexPattern := re.ReplaceAllStringFunc(pattern, func(match string) string {
+		// {param...} captura el resto de la ruta, como en http.ServeMux
+		if strings.HasSuffix(match, "...}") {
+			return `(.*)`
+		}
 		return `([^/]+)` // Grupo de captura para valores dinámicos
 	})
 
@@ -87,28 +91,42 @@ func pathMatches(compiledRegex *regexp.Regexp, url string) bool {
 	return true
 }
 
+// matchRoute return the endpoint of a route; like http.ServeMux, the catch-all {param...} endpoints only match
+// the routes that no other endpoint matches, the longest first
 func (server *Server) matchRoute(url string) *Endpoint {
+	var catchAll *Endpoint
 	for _, ep := range server.EndpointsPaths {
-		if ep.RegexPattern != nil && ep.RegexPattern.MatchString(url) {
+		if ep.RegexPattern == nil || !ep.RegexPattern.MatchString(url) {
+			continue
+		}
+		if !strings.Contains(ep.Path, "...}") {
 			return ep
 		}
+		if catchAll == nil || len(ep.Path) > len(catchAll.Path) {
+			catchAll = ep
+		}
 	}
-	return nil
+	return catchAll
 }
 
 func RequestScheme(r *http.Request) string {
-	// 1) Encabezados estándar de proxies/CDN
+	// 1) Encabezados estándar de proxies/CDN; cada proxy agrega su valor, el primero es el del cliente
 	if xf := r.Header.Get("X-Forwarded-Proto"); xf != "" {
-		return xf
+		first, _, _ := strings.Cut(xf, ",")
+		if proto := strings.ToLower(strings.TrimSpace(first)); proto != "" {
+			return proto
+		}
 	}
-	// 2) RFC 7239: Forwarded: proto=https; host=...
+	// 2) RFC 7239: Forwarded: for=...;proto=https;host=..., for=...
 	if fwd := r.Header.Get("Forwarded"); fwd != "" {
-		// búsqueda simple
-		parts := strings.Split(fwd, ";")
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if strings.HasPrefix(strings.ToLower(p), "proto=") {
-				return strings.Trim(strings.SplitN(p, "=", 2)[1], `"`)
+		// Solo el primer elemento describe la petición del cliente
+		first, _, _ := strings.Cut(fwd, ",")
+		for _, p := range strings.Split(first, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "proto") {
+				if proto := strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`)); proto != "" {
+					return proto
+				}
 			}
 		}
 	}
@@ -220,6 +220,27 @@ func TestRequestScheme_Forwarded(t *testing.T) {
 	}
 }
 
+func TestRequestScheme_ProxyChains(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		want   string
+	}{
+		{"X-Forwarded-Proto", "https, http", "https"},
+		{"X-Forwarded-Proto", "HTTPS", "https"},
+		{"Forwarded", `for=192.0.2.60;proto=https;host="example.com", for=10.0.0.1;proto=http`, "https"},
+		{"Forwarded", `for="[2001:db8::1]:4711";Proto="https"`, "https"},
+		{"Forwarded", "for=192.0.2.60, for=10.0.0.1;proto=https", "http"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(tt.header, tt.value)
+		if scheme := RequestScheme(r); scheme != tt.want {
+			t.Errorf("%s: %s => %s, want %s", tt.header, tt.value, scheme, tt.want)
+		}
+	}
+}
+
 func TestRequestScheme_TLS(t *testing.T) {
 	r := httptest.NewRequest("GET", "/", nil)
 	r.TLS = &tls.ConnectionState{}
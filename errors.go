@@ -0,0 +1,197 @@
+package nexus
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Error is an error with the HTTP status and the ErrorResponse code it must be answered with
+type Error struct {
+	Status   int
+	CodeName string            // CodeName defaults to the name of the status, e.g. "not_found"
+	Message  string            // Message is sent to the client
+	Fields   map[string]string // Fields are the errors of each invalid field
+	Err      error             // Err is the cause; it is logged but never sent to the client
+}
+
+// NewError create an error answered with the status
+func NewError(status int, message string) *Error {
+	return &Error{Status: status, CodeName: StatusCodeName(status), Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return NewError(http.StatusBadRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return NewError(http.StatusUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return NewError(http.StatusForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return NewError(http.StatusNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return NewError(http.StatusConflict, message)
+}
+
+func TooManyRequests(message string) *Error {
+	return NewError(http.StatusTooManyRequests, message)
+}
+
+// Validation create a 422 error with the errors of each field
+func Validation(message string, fields map[string]string) *Error {
+	err := NewError(http.StatusUnprocessableEntity, message)
+	err.CodeName = "validation_failed"
+	err.Fields = fields
+	return err
+}
+
+// Internal wrap an unexpected error; the client only receives a generic message
+func Internal(cause error) *Error {
+	err := NewError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	err.Err = cause
+	return err
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap set the cause of the error
+func (e *Error) Wrap(cause error) *Error {
+	e.Err = cause
+	return e
+}
+
+// WithCode replace the CodeName of the error
+func (e *Error) WithCode(codeName string) *Error {
+	e.CodeName = codeName
+	return e
+}
+
+// ErrorResponse return the body of the error
+func (e *Error) ErrorResponse() *ErrorResponse {
+	codeName := e.CodeName
+	if codeName == "" {
+		codeName = StatusCodeName(e.Status)
+	}
+	errorsMap := e.Fields
+	if errorsMap == nil {
+		errorsMap = map[string]string{"error": e.Message}
+	}
+	return &ErrorResponse{Code: e.Status, Message: e.Message, CodeName: codeName, Errors: errorsMap}
+}
+
+// ErrorRegistry map the errors returned by handlers to statuses and ErrorResponse codes
+type ErrorRegistry struct {
+	mu       sync.RWMutex
+	mappings []errorMapping
+}
+
+type errorMapping struct {
+	match    func(err error) bool
+	status   int
+	codeName string
+}
+
+// DefaultErrors is the registry used by ResponseFromError
+var DefaultErrors = NewErrorRegistry()
+
+// NewErrorRegistry create a registry with the mappings of the standard library errors
+func NewErrorRegistry() *ErrorRegistry {
+	registry := &ErrorRegistry{}
+	registry.Register(context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout")
+	registry.Register(fs.ErrNotExist, http.StatusNotFound, "not_found")
+	registry.Register(fs.ErrPermission, http.StatusForbidden, "forbidden")
+	RegisterErrorType[*http.MaxBytesError](registry, http.StatusRequestEntityTooLarge, "body_too_large")
+	return registry
+}
+
+// Register map a sentinel error, matched with errors.Is; the latest registration wins
+func (registry *ErrorRegistry) Register(target error, status int, codeName string) {
+	registry.add(func(err error) bool { return errors.Is(err, target) }, status, codeName)
+}
+
+// RegisterErrorType map every error of the type T, matched with errors.As
+func RegisterErrorType[T error](registry *ErrorRegistry, status int, codeName string) {
+	registry.add(func(err error) bool {
+		var target T
+		return errors.As(err, &target)
+	}, status, codeName)
+}
+
+// RegisterError map a sentinel error in DefaultErrors
+func RegisterError(target error, status int, codeName string) {
+	DefaultErrors.Register(target, status, codeName)
+}
+
+func (registry *ErrorRegistry) add(match func(err error) bool, status int, codeName string) {
+	if codeName == "" {
+		codeName = StatusCodeName(status)
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.mappings = append(registry.mappings, errorMapping{match: match, status: status, codeName: codeName})
+}
+
+// Resolve return the Error of err; an *Error in the chain is used as is, unknown errors are internal.
+// Mapped errors are answered with the text of their status, since their messages may reveal details
+// of the server, as the paths of fs.ErrNotExist; err is kept as the cause.
+func (registry *ErrorRegistry) Resolve(err error) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	for i := len(registry.mappings) - 1; i >= 0; i-- {
+		mapping := registry.mappings[i]
+		if mapping.match(err) {
+			resolved := NewError(mapping.status, http.StatusText(mapping.status))
+			resolved.CodeName = mapping.codeName
+			resolved.Err = err
+			return resolved
+		}
+	}
+	return Internal(err)
+}
+
+// ResponseFromError write the response of an error resolved with DefaultErrors; causes of server errors are
+// logged instead of being sent
+func ResponseFromError(w http.ResponseWriter, err error) error {
+	if err == nil {
+		return nil
+	}
+	resolved := DefaultErrors.Resolve(err)
+	if resolved.Status >= http.StatusInternalServerError && resolved.Err != nil {
+		log.Printf("nexus: %d %s: %v", resolved.Status, resolved.CodeName, resolved.Err)
+	}
+	return ResponseJsonWithError(w, resolved.Status, resolved.ErrorResponse())
+}
+
+// ErrorHandler adapt a handler that returns an error; the error is answered with ResponseFromError.
+// Endpoint.ErrorHandlerFunc is adapted automatically.
+func ErrorHandler(handler func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			ResponseFromError(w, err)
+		}
+	}
+}
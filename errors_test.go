@@ -0,0 +1,111 @@
+package nexus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errOutOfStock = errors.New("out of stock")
+
+type quotaError struct{ limit int }
+
+func (e *quotaError) Error() string { return fmt.Sprintf("quota of %d exceeded", e.limit) }
+
+func TestError_Wrap(t *testing.T) {
+	cause := errors.New("duplicated key")
+	err := Conflict("email already registered").Wrap(cause)
+
+	if !errors.Is(err, cause) {
+		t.Fatal("expected the cause in the chain")
+	}
+	if err.Status != http.StatusConflict || err.CodeName != "conflict" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if err.Error() != "email already registered: duplicated key" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
+
+func TestErrorRegistry_Resolve(t *testing.T) {
+	registry := NewErrorRegistry()
+	registry.Register(errOutOfStock, http.StatusConflict, "out_of_stock")
+	RegisterErrorType[*quotaError](registry, http.StatusTooManyRequests, "")
+
+	cases := []struct {
+		err      error
+		status   int
+		codeName string
+	}{
+		{fmt.Errorf("order 7: %w", errOutOfStock), http.StatusConflict, "out_of_stock"},
+		{fmt.Errorf("upload: %w", &quotaError{limit: 3}), http.StatusTooManyRequests, "too_many_requests"},
+		{fmt.Errorf("wrapped: %w", NotFound("product not found")), http.StatusNotFound, "not_found"},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+		{&http.MaxBytesError{Limit: 10}, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{errors.New("connection refused"), http.StatusInternalServerError, "internal_server_error"},
+	}
+	for _, c := range cases {
+		resolved := registry.Resolve(c.err)
+		if resolved.Status != c.status || resolved.CodeName != c.codeName {
+			t.Fatalf("%v: expected %d %s, got %d %s", c.err, c.status, c.codeName, resolved.Status, resolved.CodeName)
+		}
+	}
+
+	if resolved := registry.Resolve(errors.New("password=secret")); resolved.Message != "Internal Server Error" {
+		t.Fatalf("expected internal errors to be hidden, got %q", resolved.Message)
+	}
+	resolved := registry.Resolve(&fs.PathError{Op: "open", Path: "/srv/data/x", Err: fs.ErrNotExist})
+	if resolved.Message != "Not Found" || !errors.Is(resolved, fs.ErrNotExist) {
+		t.Fatalf("expected the path to be hidden and kept as cause, got %q", resolved.Message)
+	}
+}
+
+func TestEndpointErrorHandlerFunc(t *testing.T) {
+	server := &Server{ServerName: "ErrorsTest"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "POST /users", ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request) error {
+			return Validation("invalid user", map[string]string{"email": "is required"})
+		}},
+		{Path: "GET /users/{id}", ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request) error {
+			return ResponseWithJSON(w, http.StatusOK, map[string]string{"id": r.PathValue("id")})
+		}},
+	})
+	server.GroupWithOptions("/admin", []Endpoint{
+		{Path: "GET /", ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request) error {
+			return Forbidden("admins only")
+		}},
+	}, &GroupOptions{Middlewares: []func(next http.Handler) http.Handler{
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Group", "admin")
+				next.ServeHTTP(w, r)
+			})
+		},
+	}})
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/users", nil))
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusUnprocessableEntity || resp.CodeName != "validation_failed" || resp.Errors["email"] != "is required" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/users/3", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":"3"}` {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Group") != "admin" {
+		t.Fatalf("expected the group middleware and 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,232 @@
+package nexus
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagMode is the kind of validator that ConditionalRequests calculates for an endpoint
+type ETagMode int
+
+const (
+	// ETagNone do not calculate ETags
+	ETagNone ETagMode = iota
+	// ETagStrong mark the body as byte-for-byte identical, required by If-Match and range requests
+	ETagStrong
+	// ETagWeak mark the body as semantically equivalent, for representations that may vary slightly
+	ETagWeak
+)
+
+// maxETagBody is the largest body that is buffered to calculate its ETag; bigger bodies are sent without it
+const maxETagBody = 8 << 20
+
+// ETag return the validator of a body
+func ETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// ConditionalRequests calculate the ETag of the GET and HEAD responses of the endpoints with EndpointOptions.ETag,
+// answering 304 Not Modified when it matches If-None-Match. An ETag set by the handler is kept as is.
+func ConditionalRequests(next http.Handler, server *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		endpoint, ok := server.GetEndpoint(r)
+		if !ok || endpoint.Options.ETag == ETagNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{ResponseWriter: w, request: r, weak: endpoint.Options.ETag == ETagWeak}
+		next.ServeHTTP(ew, r)
+		ew.finish()
+	})
+}
+
+// CheckIfMatch evaluate the If-Match and If-Unmodified-Since preconditions of a request against the current
+// state of the resource, for optimistic concurrency on PUT, PATCH and DELETE. It writes 412 Precondition Failed
+// and returns false when they fail. An empty currentETag means the resource does not exist.
+func CheckIfMatch(w http.ResponseWriter, r *http.Request, currentETag string, lastModified time.Time) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !matchETags(ifMatch, currentETag, false) {
+			preconditionFailed(w, "the resource has been modified")
+			return false
+		}
+		return true
+	}
+
+	if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(since) {
+			preconditionFailed(w, "the resource has been modified")
+			return false
+		}
+	}
+	return true
+}
+
+// LastModified set the Last-Modified header and evaluate If-Modified-Since; it writes 304 Not Modified and
+// returns true when the client copy is fresh, so the handler must not write the body:
+//
+//	if nexus.LastModified(w, r, item.UpdatedAt) {
+//		return
+//	}
+func LastModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	// If-None-Match takes precedence when the client sent both
+	if r.Header.Get("If-None-Match") != "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	notModified(w)
+	return true
+}
+
+func preconditionFailed(w http.ResponseWriter, msg string) {
+	ResponseJsonWithError(w, http.StatusPreconditionFailed, &ErrorResponse{
+		Code:     http.StatusPreconditionFailed,
+		Message:  msg,
+		CodeName: "precondition_failed",
+		Errors:   map[string]string{"precondition": msg},
+	})
+}
+
+// notModified write a 304 keeping only the headers allowed by RFC 9110
+func notModified(w http.ResponseWriter) {
+	header := w.Header()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		header.Del(name)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// matchETags evaluate if the current ETag is in a If-Match or If-None-Match list; weak comparison ignores the W/ prefix
+func matchETags(list string, current string, weak bool) bool {
+	if current == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(current, "W/") {
+				return true
+			}
+		} else if candidate == current && !strings.HasPrefix(current, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter buffer a successful response to calculate its ETag before sending it
+type etagWriter struct {
+	http.ResponseWriter
+	request   *http.Request
+	weak      bool
+	code      int
+	buf       []byte
+	streaming bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.streaming || w.code != 0 {
+		return
+	}
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.code = code
+	// Only successful responses have a validator
+	if code != http.StatusOK {
+		w.stream()
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	if len(w.buf)+len(b) > maxETagBody {
+		w.stream()
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	return len(b), nil
+}
+
+// stream give up the ETag and send what has been buffered
+func (w *etagWriter) stream() {
+	if w.streaming {
+		return
+	}
+	w.streaming = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// finish set the ETag and answer 304 when the client copy matches
+func (w *etagWriter) finish() {
+	if w.streaming {
+		return
+	}
+	if w.code == 0 {
+		// The handler wrote nothing
+		return
+	}
+
+	header := w.Header()
+	etag := header.Get("ETag")
+	if etag == "" {
+		etag = ETag(w.buf, w.weak)
+		header.Set("ETag", etag)
+	}
+
+	if matchETags(w.request.Header.Get("If-None-Match"), etag, true) {
+		notModified(w.ResponseWriter)
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(w.buf)
+}
+
+// Flush send the response without ETag, since streamed bodies cannot be hashed in advance
+func (w *etagWriter) Flush() {
+	w.stream()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
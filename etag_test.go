@@ -0,0 +1,129 @@
+package nexus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func etagHandler(inner http.HandlerFunc) http.Handler {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /strong", Options: EndpointOptions{ETag: ETagStrong}},
+		{Path: "GET /weak", Options: EndpointOptions{ETag: ETagWeak}},
+		{Path: "GET /plain"},
+	})
+	return ConditionalRequests(inner, server)
+}
+
+func listItems(w http.ResponseWriter, r *http.Request) {
+	ResponseWithJSON(w, http.StatusOK, []string{"a", "b"})
+}
+
+func TestConditionalRequests_ETag(t *testing.T) {
+	handler := etagHandler(listItems)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/strong", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) || w.Body.String() != `["a","b"]` {
+		t.Fatalf("unexpected response %d %q %q", w.Code, etag, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/strong", nil)
+	r.Header.Set("If-None-Match", `"other", `+etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "" || w.Header().Get("ETag") != etag {
+		t.Fatal("expected the ETag and no Content-Type on 304")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/weak", nil))
+	if weak := w.Header().Get("ETag"); weak != "W/"+etag {
+		t.Fatalf("expected weak ETag W/%s, got %q", etag, weak)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/plain", nil))
+	if w.Header().Get("ETag") != "" {
+		t.Fatal("expected no ETag on endpoints without the option")
+	}
+}
+
+func TestConditionalRequests_SkipsErrors(t *testing.T) {
+	handler := etagHandler(func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithError(w, http.StatusNotFound, "not found")
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/strong", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+		t.Fatalf("expected 404 without ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	current := ETag([]byte("v1"), false)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		header, value string
+		expected      bool
+	}{
+		{"", "", true},
+		{"If-Match", current, true},
+		{"If-Match", `"stale"`, false},
+		{"If-Match", "W/" + current, false},
+		{"If-Match", "*", true},
+		{"If-Unmodified-Since", modified.Format(http.TimeFormat), true},
+		{"If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/items/1", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		w := httptest.NewRecorder()
+		if got := CheckIfMatch(w, r, current, modified); got != c.expected {
+			t.Fatalf("%s %q: expected %v", c.header, c.value, c.expected)
+		}
+		if !c.expected && w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("expected 412, got %d", w.Code)
+		}
+	}
+
+	r := httptest.NewRequest("PUT", "/items/1", nil)
+	r.Header.Set("If-Match", "*")
+	if CheckIfMatch(httptest.NewRecorder(), r, "", time.Time{}) {
+		t.Fatal("expected If-Match * to fail for a missing resource")
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 500, time.UTC)
+
+	r := httptest.NewRequest("GET", "/items", nil)
+	w := httptest.NewRecorder()
+	if LastModified(w, r, modified) {
+		t.Fatal("expected a full response without If-Modified-Since")
+	}
+	if w.Header().Get("Last-Modified") != "Tue, 02 Jan 2024 03:04:05 GMT" {
+		t.Fatalf("unexpected Last-Modified %q", w.Header().Get("Last-Modified"))
+	}
+
+	r.Header.Set("If-Modified-Since", "Tue, 02 Jan 2024 03:04:05 GMT")
+	w = httptest.NewRecorder()
+	if !LastModified(w, r, modified) || w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	if LastModified(w, r, modified.Add(time.Minute)) {
+		t.Fatal("expected a full response for a newer resource")
+	}
+}
@@ -2,4 +2,8 @@ module github.com/codecraftkit/nexus
 
 go 1.25.0
 
-require github.com/rs/cors v1.11.1
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/rs/cors v1.11.1
+	golang.org/x/crypto v0.54.0
+)
@@ -14,12 +14,40 @@ func Health(server *Server) http.HandlerFunc {
 	}
 }
 
-// RoutesList return all routes
+// RouteInfo describe a route and its access requirements
+type RouteInfo struct {
+	Path                     string   `json:"path"`
+	IsPublic                 bool     `json:"is_public"`
+	NoRequiresAuthentication bool     `json:"no_requires_authentication"`
+	RequiredScopes           []string `json:"required_scopes,omitempty"`
+	AnyScopes                []string `json:"any_scopes,omitempty"`
+	RequiredRoles            []string `json:"required_roles,omitempty"`
+	AnyRoles                 []string `json:"any_roles,omitempty"`
+}
+
+// RoutesList return all routes; with ?details=true every route includes its access requirements
 func RoutesList(server *Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var routes map[string]string = make(map[string]string)
 		endpoints := server.GetEndpoints()
 
+		if r.URL.Query().Get("details") == "true" {
+			details := make(map[string]RouteInfo)
+			for _, endpoint := range endpoints {
+				details[endpoint.Path] = RouteInfo{
+					Path:                     endpoint.Path,
+					IsPublic:                 endpoint.Options.IsPublic,
+					NoRequiresAuthentication: endpoint.Options.NoRequiresAuthentication,
+					RequiredScopes:           endpoint.Options.RequiredScopes,
+					AnyScopes:                endpoint.Options.AnyScopes,
+					RequiredRoles:            endpoint.Options.RequiredRoles,
+					AnyRoles:                 endpoint.Options.AnyRoles,
+				}
+			}
+			ResponseWithJSON(w, http.StatusOK, details)
+			return
+		}
+
 		for _, endpoint := range endpoints {
 			//paths := strings.Split(endpoint.Path, " ")
 			//pathName := strings.Replace(paths[1][1:], "/", "_", -1)
@@ -56,3 +84,6 @@ var ServerEndpoints = []Endpoint{
 	{Path: "GET /_routes", HandlerServerFunc: RoutesList, Options: EndpointOptions{IsPublic: true, NoRequiresAuthentication: true, IgnorePrefix: true}},
 	{Path: "GET /_routes/raw", HandlerServerFunc: RawRoutesList, Options: EndpointOptions{IsPublic: true, NoRequiresAuthentication: true, IgnorePrefix: true}},
 }
+
+// openAPIEndpoint serve the OpenAPI document when Settings.OpenAPI is enabled; it is protected like the other endpoints
+var openAPIEndpoint = Endpoint{Path: "GET /_openapi", HandlerServerFunc: OpenAPIHandler, Options: EndpointOptions{IgnorePrefix: true}}
@@ -59,6 +59,30 @@ func TestRoutesList(t *testing.T) {
 	}
 }
 
+func TestRoutesList_Details(t *testing.T) {
+	server := &Server{
+		EndpointsPaths: make(map[string]*Endpoint),
+	}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /reports", Options: EndpointOptions{RequiredScopes: []string{"reports:read"}}},
+	})
+
+	handler := RoutesList(server)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/_routes?details=true", nil)
+	handler(w, r)
+
+	var routes map[string]RouteInfo
+	json.Unmarshal(w.Body.Bytes(), &routes)
+	route, ok := routes["GET /reports"]
+	if !ok {
+		t.Fatalf("missing GET /reports in routes map")
+	}
+	if len(route.RequiredScopes) != 1 || route.RequiredScopes[0] != "reports:read" {
+		t.Fatalf("expected required scopes, got %v", route.RequiredScopes)
+	}
+}
+
 func TestRawRoutesList(t *testing.T) {
 	// Note: RawRoutesList attempts to JSON-marshal []Endpoint, but Endpoint
 	// contains function-type fields (HandlerFunc, HandlerServerFunc) which
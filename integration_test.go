@@ -2,65 +2,15 @@ package nexus
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
-
-	"github.com/rs/cors"
 )
 
-// buildTestHandler replicates the wiring logic of Run() but returns an
-// http.Handler instead of starting a listener. This allows full end-to-end
-// testing with httptest.
+// buildTestHandler returns the wired http.Handler of the server instead of
+// starting a listener. This allows full end-to-end testing with httptest.
 func buildTestHandler(server *Server) http.Handler {
-	if server.Settings == nil {
-		server.Settings = &Settings{}
-	}
-
-	if server.ServerName == "" {
-		if server.ServerNumber == "" {
-			server.ServerNumber = "0"
-		}
-		server.ServerName = fmt.Sprintf("Server %s", server.ServerNumber)
-	}
-
-	mux := http.NewServeMux()
-
-	// Add built-in endpoints
-	server.Endpoints = append(server.Endpoints, ServerEndpoints)
-
-	for i, endpoints := range server.Endpoints {
-		for j, endpoint := range endpoints {
-			if !endpoint.Options.IgnorePrefix {
-				endpoint.Path = strings.Replace(
-					endpoint.Path,
-					" /",
-					fmt.Sprintf(" %s/", server.Settings.PathPrefix),
-					-1,
-				)
-			}
-			server.Endpoints[i][j] = endpoint
-
-			if endpoint.HandlerFunc != nil && endpoint.Handler != nil {
-				panic("Endpoint cannot have both HandlerFunc and Handler")
-			}
-			if endpoint.HandlerServerFunc != nil {
-				mux.HandleFunc(endpoint.Path, endpoint.HandlerServerFunc(server))
-			}
-			if endpoint.HandlerFunc != nil {
-				mux.HandleFunc(endpoint.Path, endpoint.HandlerFunc)
-			}
-			if endpoint.Handler != nil {
-				mux.Handle(endpoint.Path, endpoint.Handler)
-			}
-		}
-		server.setEndpoints(endpoints)
-	}
-
-	c := cors.New(server.CorsOptions)
-	return c.Handler(server.ApplyMiddlewares(mux))
+	return server.Handler()
 }
 
 // --- Integration Tests ---
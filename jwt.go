@@ -0,0 +1,495 @@
+package nexus
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contextKey is the type of the keys nexus stores in the request context
+type contextKey string
+
+const claimsContextKey contextKey = "nexus.claims"
+
+var (
+	ErrTokenMissing     = errors.New("token is missing")
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenAlgorithm   = errors.New("token algorithm is not allowed")
+	ErrTokenKeyNotFound = errors.New("token signing key not found")
+	ErrTokenSignature   = errors.New("token signature is invalid")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("token issuer is invalid")
+	ErrTokenAudience    = errors.New("token audience is invalid")
+)
+
+// JWTOptions contains the configuration of the JWTAuth middleware
+type JWTOptions struct {
+	Key               interface{}            // Key is used when the token has no kid or no key matches it ([]byte, *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey)
+	Keys              map[string]interface{} // Keys are static keys indexed by kid
+	JWKSFile          string                 // JWKSFile is a local JSON Web Key Set file
+	JWKSURL           string                 // JWKSURL is a remote JSON Web Key Set
+	JWKSRefresh       time.Duration          // JWKSRefresh is how long a fetched key set is cached (default 1h)
+	JWKSMinRefresh    time.Duration          // JWKSMinRefresh limits refetches triggered by unknown kids (default 1m)
+	HTTPClient        *http.Client           // HTTPClient is used to fetch JWKSURL
+	Algorithms        []string               // Algorithms allowed; defaults to HS256, RS256, ES256 and EdDSA
+	Issuer            string                 // Issuer, when set, must match the iss claim
+	Audience          []string               // Audience, when set, must intersect the aud claim
+	Leeway            time.Duration          // Leeway tolerated on exp and nbf
+	Realm             string                 // Realm sent in the WWW-Authenticate challenge
+	RequireExpiration bool                   // RequireExpiration rejects tokens without exp
+	now               func() time.Time
+}
+
+// NumericDate is a JWT timestamp expressed in seconds since the epoch
+type NumericDate struct {
+	time.Time
+}
+
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	sec := int64(f)
+	d.Time = time.Unix(sec, int64((f-float64(sec))*1e9))
+	return nil
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Unix())
+}
+
+// Audience is the aud claim, which may be a string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims are the registered claims of a verified token
+type Claims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  Audience     `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+	Scope     string       `json:"scope,omitempty"`
+	Roles     []string     `json:"roles,omitempty"`
+	raw       []byte
+}
+
+// Scopes return the space separated scope claim as a slice
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Decode unmarshal the full token payload into v, so private claims can be read into a custom type
+func (c *Claims) Decode(v interface{}) error {
+	return json.Unmarshal(c.raw, v)
+}
+
+// ClaimsFromContext return the claims stored by the JWTAuth middleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+func contextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ContextClaims decode the claims of the request into a custom claims type
+func ContextClaims[T any](r *http.Request) (T, bool) {
+	var out T
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return out, false
+	}
+	if err := claims.Decode(&out); err != nil {
+		return out, false
+	}
+	return out, true
+}
+
+// JWTVerifier verify JSON Web Tokens against static keys or a JSON Web Key Set
+type JWTVerifier struct {
+	options    JWTOptions
+	algorithms map[string]bool
+
+	mu        sync.Mutex
+	jwks      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// NewJWTVerifier create a verifier with the given options
+func NewJWTVerifier(options JWTOptions) *JWTVerifier {
+	if options.JWKSRefresh == 0 {
+		options.JWKSRefresh = time.Hour
+	}
+	if options.JWKSMinRefresh == 0 {
+		options.JWKSMinRefresh = time.Minute
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+	algorithms := options.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+	}
+	v := &JWTVerifier{options: options, algorithms: make(map[string]bool)}
+	for _, alg := range algorithms {
+		v.algorithms[alg] = true
+	}
+	return v
+}
+
+// Verify check the signature and the registered claims of a compact token
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, ErrTokenAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := &Claims{raw: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) validateClaims(claims *Claims) error {
+	now := v.options.now()
+	leeway := v.options.Leeway
+
+	if claims.ExpiresAt == nil && v.options.RequireExpiration {
+		return ErrTokenExpired
+	}
+	if claims.ExpiresAt != nil && !now.Before(claims.ExpiresAt.Add(leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time) {
+		return ErrTokenNotYetValid
+	}
+	if v.options.Issuer != "" && claims.Issuer != v.options.Issuer {
+		return ErrTokenIssuer
+	}
+	if len(v.options.Audience) > 0 {
+		for _, expected := range v.options.Audience {
+			for _, aud := range claims.Audience {
+				if aud == expected {
+					return nil
+				}
+			}
+		}
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+// key resolve the verification key for a kid, refreshing the key set when the kid is unknown
+func (v *JWTVerifier) key(kid string) (interface{}, error) {
+	if kid != "" {
+		if key, ok := v.options.Keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	if v.options.JWKSFile != "" || v.options.JWKSURL != "" {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		now := v.options.now()
+		stale := v.jwks == nil || now.Sub(v.fetchedAt) > v.options.JWKSRefresh
+		_, known := v.jwks[kid]
+		canRefetch := now.Sub(v.fetchedAt) > v.options.JWKSMinRefresh
+		if stale || (!known && canRefetch) {
+			keys, err := v.loadJWKS()
+			if err != nil && v.jwks == nil {
+				return nil, err
+			}
+			if err == nil {
+				v.jwks = keys
+				v.fetchedAt = now
+			}
+		}
+		if key, ok := v.jwks[kid]; ok {
+			return key, nil
+		}
+		// A set with a single key can verify tokens without kid
+		if kid == "" && len(v.jwks) == 1 {
+			for _, key := range v.jwks {
+				return key, nil
+			}
+		}
+	}
+
+	if v.options.Key != nil {
+		return v.options.Key, nil
+	}
+	return nil, ErrTokenKeyNotFound
+}
+
+func (v *JWTVerifier) loadJWKS() (map[string]interface{}, error) {
+	var data []byte
+	var err error
+	if v.options.JWKSFile != "" {
+		data, err = os.ReadFile(v.options.JWKSFile)
+	} else {
+		var resp *http.Response
+		resp, err = v.options.HTTPClient.Get(v.options.JWKSURL)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("jwks: unexpected status %d", resp.StatusCode)
+			}
+			data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parse a JSON Web Key Set and return its public keys indexed by kid
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				return nil, fmt.Errorf("jwks: invalid RSA key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("jwks: invalid EC key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		case "OKP":
+			if jwk.Crv != "Ed25519" {
+				continue
+			}
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("jwks: invalid OKP key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = ed25519.PublicKey(x)
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwks: invalid oct key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = k
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature check the signature with the key type that belongs to the algorithm,
+// so a public key can never be used as an HMAC secret
+func verifySignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrTokenKeyNotFound
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrTokenSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenKeyNotFound
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return ErrTokenSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrTokenKeyNotFound
+		}
+		if len(signature) != 64 {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrTokenSignature
+		}
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrTokenKeyNotFound
+		}
+		if !ed25519.Verify(pub, []byte(signingInput), signature) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrTokenAlgorithm
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// BearerToken return the token of an "Authorization: Bearer" header
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// JWTAuth return a middleware that require a valid bearer token on every endpoint
+// that is not marked as NoRequiresAuthentication; the verified claims are stored in the request context
+func JWTAuth(options JWTOptions) func(next http.Handler, server *Server) http.Handler {
+	verifier := NewJWTVerifier(options)
+	realm := options.Realm
+	if realm == "" {
+		realm = "nexus"
+	}
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if server.NoRequiresAuthentication(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := BearerToken(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, realm))
+				ResponseWithError(w, http.StatusUnauthorized, ErrTokenMissing.Error())
+				return
+			}
+
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description="%s"`, realm, err.Error()))
+				ResponseWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			ctx := contextWithClaims(r.Context(), claims)
+			ctx = ContextWithPrincipal(ctx, &Principal{
+				Subject: claims.Subject,
+				Method:  "jwt",
+				Scopes:  claims.Scopes(),
+				Roles:   claims.Roles,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
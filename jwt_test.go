@@ -0,0 +1,292 @@
+package nexus
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signTestToken build a compact JWT signed with the given algorithm and private key
+func signTestToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "EdDSA":
+		sig = ed25519.Sign(key.(ed25519.PrivateKey), []byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   "nexus-api",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+}
+
+// --- JWTVerifier ---
+
+func TestJWTVerifier_HS256(t *testing.T) {
+	secret := []byte("s3cr3t")
+	verifier := NewJWTVerifier(JWTOptions{Key: secret, Issuer: "https://issuer.test", Audience: []string{"nexus-api"}})
+
+	claims, err := verifier.Verify(signTestToken(t, "HS256", "", secret, validClaims()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != "user-1" {
+		t.Fatalf("expected sub user-1, got %s", claims.Subject)
+	}
+	if scopes := claims.Scopes(); len(scopes) != 2 || scopes[1] != "write" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+}
+
+func TestJWTVerifier_AsymmetricAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	verifier := NewJWTVerifier(JWTOptions{Keys: map[string]interface{}{
+		"rsa": &rsaKey.PublicKey,
+		"ec":  &ecKey.PublicKey,
+		"ed":  edPub,
+	}})
+
+	tokens := map[string]string{
+		"RS256": signTestToken(t, "RS256", "rsa", rsaKey, validClaims()),
+		"ES256": signTestToken(t, "ES256", "ec", ecKey, validClaims()),
+		"EdDSA": signTestToken(t, "EdDSA", "ed", edPriv, validClaims()),
+	}
+	for alg, token := range tokens {
+		if _, err := verifier.Verify(token); err != nil {
+			t.Fatalf("%s: unexpected error: %v", alg, err)
+		}
+	}
+}
+
+func TestJWTVerifier_RejectsInvalidTokens(t *testing.T) {
+	secret := []byte("s3cr3t")
+	verifier := NewJWTVerifier(JWTOptions{Key: secret, Issuer: "https://issuer.test", Audience: []string{"nexus-api"}})
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	notYet := validClaims()
+	notYet["nbf"] = time.Now().Add(time.Hour).Unix()
+	wrongIss := validClaims()
+	wrongIss["iss"] = "https://other.test"
+	wrongAud := validClaims()
+	wrongAud["aud"] = []string{"other"}
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"malformed", "abc.def", ErrTokenMalformed},
+		{"expired", signTestToken(t, "HS256", "", secret, expired), ErrTokenExpired},
+		{"nbf", signTestToken(t, "HS256", "", secret, notYet), ErrTokenNotYetValid},
+		{"issuer", signTestToken(t, "HS256", "", secret, wrongIss), ErrTokenIssuer},
+		{"audience", signTestToken(t, "HS256", "", secret, wrongAud), ErrTokenAudience},
+		{"signature", signTestToken(t, "HS256", "", []byte("other"), validClaims()), ErrTokenSignature},
+		{"none", signTestToken(t, "none", "", nil, validClaims()), ErrTokenAlgorithm},
+	}
+	for _, c := range cases {
+		if _, err := verifier.Verify(c.token); !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestJWTVerifier_AlgorithmKeyConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	verifier := NewJWTVerifier(JWTOptions{Key: &rsaKey.PublicKey})
+
+	// An HS256 token must never be verified with an RSA public key
+	token := signTestToken(t, "HS256", "", []byte("whatever"), validClaims())
+	if _, err := verifier.Verify(token); !errors.Is(err, ErrTokenKeyNotFound) {
+		t.Fatalf("expected ErrTokenKeyNotFound, got %v", err)
+	}
+}
+
+func TestJWTVerifier_Leeway(t *testing.T) {
+	secret := []byte("s3cr3t")
+	verifier := NewJWTVerifier(JWTOptions{Key: secret, Leeway: time.Minute})
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := verifier.Verify(signTestToken(t, "HS256", "", secret, claims)); err != nil {
+		t.Fatalf("expected token within leeway to pass, got %v", err)
+	}
+}
+
+// --- JWKS ---
+
+func writeJWKS(t *testing.T, path string, keys map[string]*ecdsa.PublicKey) {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": kid,
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	data, _ := json.Marshal(set)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTVerifier_JWKSFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeJWKS(t, path, map[string]*ecdsa.PublicKey{"k1": &oldKey.PublicKey})
+
+	now := time.Now()
+	verifier := NewJWTVerifier(JWTOptions{JWKSFile: path, now: func() time.Time { return now }})
+
+	if _, err := verifier.Verify(signTestToken(t, "ES256", "k1", oldKey, validClaims())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Rotate the key set: the unknown kid is only refetched after JWKSMinRefresh
+	writeJWKS(t, path, map[string]*ecdsa.PublicKey{"k2": &newKey.PublicKey})
+	rotated := signTestToken(t, "ES256", "k2", newKey, validClaims())
+	if _, err := verifier.Verify(rotated); !errors.Is(err, ErrTokenKeyNotFound) {
+		t.Fatalf("expected ErrTokenKeyNotFound before refresh, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := verifier.Verify(rotated); err != nil {
+		t.Fatalf("expected rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestJWTVerifier_JWKSURL(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	pub := edPriv.Public().(ed25519.PublicKey)
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"ed","x":"%s"}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	}))
+	defer ts.Close()
+
+	verifier := NewJWTVerifier(JWTOptions{JWKSURL: ts.URL})
+	token := signTestToken(t, "EdDSA", "ed", edPriv, validClaims())
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(token); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the key set to be cached, fetched %d times", fetches)
+	}
+}
+
+// --- JWTAuth middleware ---
+
+func TestJWTAuth_Middleware(t *testing.T) {
+	secret := []byte("s3cr3t")
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /private"},
+		{Path: "GET /open", Options: EndpointOptions{NoRequiresAuthentication: true}},
+	})
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if ok {
+			w.Write([]byte(claims.Subject))
+		}
+	})
+	handler := JWTAuth(JWTOptions{Key: secret})(inner, server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/open", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for NoRequiresAuthentication endpoint, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/private", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("expected WWW-Authenticate challenge")
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, "HS256", "", secret, validClaims()))
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "user-1" {
+		t.Fatalf("expected 200 with subject, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestContextClaims_CustomType(t *testing.T) {
+	secret := []byte("s3cr3t")
+	claims := validClaims()
+	claims["tenant"] = "acme"
+	token := signTestToken(t, "HS256", "", secret, claims)
+
+	verified, err := NewJWTVerifier(JWTOptions{Key: secret}).Verify(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(contextWithClaims(r.Context(), verified))
+
+	custom, ok := ContextClaims[struct {
+		Tenant string `json:"tenant"`
+	}](r)
+	if !ok || custom.Tenant != "acme" {
+		t.Fatalf("expected tenant acme, got %+v", custom)
+	}
+}
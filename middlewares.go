@@ -1,13 +1,17 @@
 package nexus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // ApplyMiddlewares apply all middlewares to the mux;
 // if the server is in debug mode, the server will be register the LogRequest middleware that will log the request on the console
-// if the server has a secret, the server will be register the ValidateSecret middleware that will check if the request has a secret
+// if the server has SecurityHeaders, the server will be register the SecurityHeadersMiddleware
+// if Settings.ProblemDetails is enabled, the server will be register the ProblemDetailsMiddleware
+// if the server has a secret or a SecretProvider and Settings.IgnoreSecret is false, the server will be register the ValidateSecret middleware that will check if the request has a secret
 func (server *Server) ApplyMiddlewares(mux http.Handler) http.Handler {
 
 	// Apply all middlewares
@@ -16,14 +20,19 @@ func (server *Server) ApplyMiddlewares(mux http.Handler) http.Handler {
 	}
 
 	// If the server has a secret, the server will be register the ValidateSecret middleware that will check if the request has a secret
-	//if server.Secret != "" && server.SecretMiddleware == nil && !server.Settings.IgnoreSecret {
-	//	mux = server.ValidateSecret(mux)
-	//}
+	if (server.Secret != "" || server.SecretProvider != nil) && (server.Settings == nil || !server.Settings.IgnoreSecret) {
+		mux = server.ValidateSecret(mux)
+	}
 
-	//fmt.Println(server.Settings.IgnoreSecret)
-	//if server.SecretMiddleware != nil {
-	//	mux = server.SecretMiddleware(mux, server)
-	//}
+	// If the server has security headers, they are added before any other middleware can write the response
+	if server.SecurityHeaders != nil {
+		mux = server.SecurityHeadersMiddleware(mux)
+	}
+
+	// If the server sends Problem Details, every middleware and handler must see the marker of the response
+	if server.Settings != nil && server.Settings.ProblemDetails {
+		mux = server.ProblemDetailsMiddleware(mux)
+	}
 
 	// If the server is in debug mode, the server will be register the LogRequest middleware that will log the request on the console
 	if server.Debug {
@@ -60,16 +69,19 @@ func (server *Server) ValidateSecret(next http.Handler) http.Handler {
 		}
 
 		// Evaluate secret
-		secret := r.Header.Get("x-secret")
+		secret := server.requestSecret(r)
 
-		// If the secret is empty or not equal to the server's secret, the request is unauthorized (Status 401)
-		// TODO: optimize this check
-		if secret == "" || secret != server.Secret {
+		// If the secret is empty or does not match an active key, the request is unauthorized (Status 401)
+		key, ok := matchSecret(server.secretKeys(), secret, time.Now())
+		if secret == "" || !ok {
 			http.Error(w, "Unauthorized: Invalid secret [nexus]", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// The user middlewares run after the secret, so an auth middleware can replace this principal
+		ctx := context.WithValue(r.Context(), secretLabelContextKey, key.Label)
+		ctx = ContextWithPrincipal(ctx, &Principal{Subject: key.Label, Method: "secret", Scopes: key.Scopes})
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
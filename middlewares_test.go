@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // --- ApplyMiddlewares ---
@@ -302,3 +303,126 @@ func TestValidateSecret_MissingSecret(t *testing.T) {
 		t.Fatalf("expected 401 with missing secret, got %d", w.Code)
 	}
 }
+
+func TestValidateSecret_ProviderRotation(t *testing.T) {
+	server := &Server{
+		SecretProvider: StaticSecrets{
+			{Label: "2024", Value: "old", ExpiresAt: time.Now().Add(-time.Hour)},
+			{Label: "2025", Value: "new"},
+		},
+		EndpointsPaths: make(map[string]*Endpoint),
+	}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /private"},
+	})
+
+	var label string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		label, _ = SecretLabelFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := server.ValidateSecret(inner)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("x-secret", "new")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || label != "2025" {
+		t.Fatalf("expected 200 with label 2025, got %d %q", w.Code, label)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("x-secret", "old")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with expired secret, got %d", w.Code)
+	}
+}
+
+func TestValidateSecret_CustomHeaderAndQuery(t *testing.T) {
+	server := &Server{
+		Secret:         "mysecret",
+		Settings:       &Settings{SecretHeader: "X-Api-Key", SecretQueryParam: "api_key"},
+		EndpointsPaths: make(map[string]*Endpoint),
+	}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /private"},
+	})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := server.ValidateSecret(inner)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("X-Api-Key", "mysecret")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with custom header, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/private?api_key=mysecret", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with query param, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("x-secret", "mysecret")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with default header, got %d", w.Code)
+	}
+}
+
+func TestApplyMiddlewares_SecretWiring(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &Server{Secret: "mysecret", Settings: &Settings{}, EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{{Path: "GET /private"}})
+	w := httptest.NewRecorder()
+	server.ApplyMiddlewares(inner).ServeHTTP(w, httptest.NewRequest("GET", "/private", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when secret is configured, got %d", w.Code)
+	}
+
+	server.Settings.IgnoreSecret = true
+	w = httptest.NewRecorder()
+	server.ApplyMiddlewares(inner).ServeHTTP(w, httptest.NewRequest("GET", "/private", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 when IgnoreSecret is set, got %d", w.Code)
+	}
+}
+
+func TestApplyMiddlewares_SecretPrincipalBeforeUserMiddlewares(t *testing.T) {
+	server := &Server{Secret: "mysecret", EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{{Path: "GET /private"}})
+
+	// A user middleware runs after the secret and can replace its principal
+	var seen, final *Principal
+	server.Middlewares = []func(next http.Handler, server *Server) http.Handler{
+		func(next http.Handler, server *Server) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = PrincipalFromContext(r.Context())
+				next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), &Principal{Subject: "user-1", Method: "jwt"})))
+			})
+		},
+	}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		final, _ = PrincipalFromContext(r.Context())
+	})
+
+	r := httptest.NewRequest("GET", "/private", nil)
+	r.Header.Set("x-secret", "mysecret")
+	server.ApplyMiddlewares(inner).ServeHTTP(httptest.NewRecorder(), r)
+	if seen == nil || seen.Method != "secret" {
+		t.Fatalf("expected the secret principal in the user middleware, got %+v", seen)
+	}
+	if final == nil || final.Subject != "user-1" {
+		t.Fatalf("expected the principal of the user middleware, got %+v", final)
+	}
+}
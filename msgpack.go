@@ -0,0 +1,243 @@
+package nexus
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// EncodeMsgPack write the payload as MessagePack; structs are maps with the JSON names of the fields,
+// honouring omitempty, times are RFC 3339 strings and the json.Marshaler types are encoded from their JSON
+func EncodeMsgPack(w io.Writer, payload any) error {
+	e := &msgpackEncoder{w: bufio.NewWriter(w)}
+	if err := e.encode(reflect.ValueOf(payload)); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+type msgpackEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return e.w.WriteByte(0xc0)
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return e.w.WriteByte(0xc0)
+	}
+
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case time.Time:
+			e.writeString(value.Format(time.RFC3339Nano))
+			return nil
+		case json.Marshaler:
+			// The JSON of the type, e.g. a json.RawMessage or a NumericDate, is encoded again as MessagePack
+			data, err := value.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			var decoded any
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				return err
+			}
+			return e.encode(reflect.ValueOf(decoded))
+		case encoding.TextMarshaler:
+			text, err := value.MarshalText()
+			if err != nil {
+				return err
+			}
+			e.writeString(string(text))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return e.w.WriteByte(0xc3)
+		}
+		return e.w.WriteByte(0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.w.WriteByte(0xca)
+		binary.Write(e.w, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.w.WriteByte(0xcb)
+		binary.Write(e.w, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return e.w.WriteByte(0xc0)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBinary(v)
+			return nil
+		}
+		e.writeHeader(v.Len(), 0x90, 0xdc, 0xdd, 16)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return e.w.WriteByte(0xc0)
+		}
+		keys := v.MapKeys()
+		// Sorted keys make the output deterministic
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface()) })
+		e.writeHeader(len(keys), 0x80, 0xde, 0xdf, 16)
+		for _, key := range keys {
+			if err := e.encode(key); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	type member struct {
+		name  string
+		value reflect.Value
+	}
+	var members []member
+	for _, field := range structFields(v.Type()) {
+		name, omitempty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+		value, err := v.FieldByIndexErr(field.Index)
+		if err != nil {
+			// A nil embedded pointer has no fields
+			continue
+		}
+		if omitempty && value.IsZero() {
+			continue
+		}
+		members = append(members, member{name, value})
+	}
+
+	e.writeHeader(len(members), 0x80, 0xde, 0xdf, 16)
+	for _, m := range members {
+		e.writeString(m.name)
+		if err := e.encode(m.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader write the length of an array or a map in its fix, 16 or 32 bits form
+func (e *msgpackEncoder) writeHeader(n int, fix byte, code16 byte, code32 byte, fixMax int) {
+	switch {
+	case n < fixMax:
+		e.w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(code16)
+		binary.Write(e.w, binary.BigEndian, uint16(n))
+	default:
+		e.w.WriteByte(code32)
+		binary.Write(e.w, binary.BigEndian, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) writeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.w.WriteByte(0xd9)
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xda)
+		binary.Write(e.w, binary.BigEndian, uint16(n))
+	default:
+		e.w.WriteByte(0xdb)
+		binary.Write(e.w, binary.BigEndian, uint32(n))
+	}
+	e.w.WriteString(s)
+}
+
+func (e *msgpackEncoder) writeBinary(v reflect.Value) {
+	data := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(data), v)
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		e.w.WriteByte(0xc4)
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xc5)
+		binary.Write(e.w, binary.BigEndian, uint16(n))
+	default:
+		e.w.WriteByte(0xc6)
+		binary.Write(e.w, binary.BigEndian, uint32(n))
+	}
+	e.w.Write(data)
+}
+
+// writeInt use the smallest representation of the value
+func (e *msgpackEncoder) writeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.writeUint(uint64(n))
+	case n >= -32:
+		e.w.WriteByte(byte(int8(n)))
+	case n >= math.MinInt8:
+		e.w.WriteByte(0xd0)
+		e.w.WriteByte(byte(int8(n)))
+	case n >= math.MinInt16:
+		e.w.WriteByte(0xd1)
+		binary.Write(e.w, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		e.w.WriteByte(0xd2)
+		binary.Write(e.w, binary.BigEndian, int32(n))
+	default:
+		e.w.WriteByte(0xd3)
+		binary.Write(e.w, binary.BigEndian, n)
+	}
+}
+
+func (e *msgpackEncoder) writeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		e.w.WriteByte(0xcc)
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xcd)
+		binary.Write(e.w, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		e.w.WriteByte(0xce)
+		binary.Write(e.w, binary.BigEndian, uint32(n))
+	default:
+		e.w.WriteByte(0xcf)
+		binary.Write(e.w, binary.BigEndian, n)
+	}
+}
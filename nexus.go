@@ -7,13 +7,48 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/rs/cors"
 )
 
 // Run a new Server
 func (server *Server) Run() {
 
+	handler := server.Handler()
+
+	port := server.Port
+	if port == "" {
+		port = "8080"
+	}
+
+	httpServer := &http.Server{
+		Addr:         fmt.Sprintf(":%s", port),
+		Handler:      handler,
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+
+	if server.RunningServerMessage == "" {
+		server.RunningServerMessage = fmt.Sprintf("[%s] Server running on port %s\n", server.ServerName, httpServer.Addr)
+	}
+
+	fmt.Print(server.RunningServerMessage)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+
+}
+
+// Handler register the endpoints and return the server's http.Handler with the CORS policies and the middlewares;
+// Run serves it, and it can also be mounted in another server or used with httptest. The handler is built on the
+// first call and returned by the next ones, so the endpoints and middlewares added later are not served.
+func (server *Server) Handler() http.Handler {
+	server.handlerOnce.Do(func() {
+		server.handler = server.buildHandler()
+	})
+	return server.handler
+}
+
+func (server *Server) buildHandler() http.Handler {
+
 	if server.Settings == nil {
 		server.Settings = &Settings{}
 	}
@@ -30,6 +65,9 @@ func (server *Server) Run() {
 
 	// Add the basic endpoints from the library
 	server.Endpoints = append(server.Endpoints, ServerEndpoints)
+	if server.Settings.OpenAPI {
+		server.Endpoints = append(server.Endpoints, []Endpoint{openAPIEndpoint})
+	}
 
 	// Add the endpoints from the user setup
 	for i, endpoints := range server.Endpoints {
@@ -45,51 +83,49 @@ func (server *Server) Run() {
 			}
 			server.Endpoints[i][j] = endpoint
 
-			// If an endpoint has both Handler and HandlerFunc the server going to crash
-			if endpoint.HandlerFunc != nil && endpoint.Handler != nil {
-				panic("Endpoint cannot have both HandlerFunc and Handler")
+			// If an endpoint has two handlers the server going to crash
+			if !endpoint.grouped {
+				endpoint.checkHandlers()
+			}
+			// The endpoint limits are applied before any handler reads the body
+			handle := func(handler http.Handler) {
+				mux.Handle(endpoint.Path, limitBody(endpoint.Options.MaxBodyBytes, handler))
 			}
 			if endpoint.HandlerServerFunc != nil {
-				mux.HandleFunc(endpoint.Path, endpoint.HandlerServerFunc(server))
+				handle(endpoint.HandlerServerFunc(server))
 			}
 			if endpoint.HandlerFunc != nil {
-				mux.HandleFunc(endpoint.Path, endpoint.HandlerFunc)
+				handle(endpoint.HandlerFunc)
 			}
 			if endpoint.Handler != nil {
-				mux.Handle(endpoint.Path, endpoint.Handler)
+				handle(endpoint.Handler)
+			}
+			if endpoint.ErrorHandlerFunc != nil {
+				handle(ErrorHandler(endpoint.ErrorHandlerFunc))
+			}
+			if endpoint.TypedHandler != nil && endpoint.Handler == nil {
+				handle(endpoint.TypedHandler)
+			}
+			if endpoint.WebSocket != nil {
+				// The origins of the handshake are checked against the CORS options of the server
+				endpoint.WebSocket.server = server
+				if endpoint.Handler == nil {
+					handle(endpoint.WebSocket)
+				}
+			}
+			if endpoint.Proxy != nil && endpoint.Handler == nil {
+				handle(endpoint.Proxy)
 			}
 		}
 
 		server.setEndpoints(endpoints)
 	}
 
-	port := server.Port
-	if port == "" {
-		port = "8080"
-	}
-
-	c := cors.New(server.CorsOptions)
-
-	httpServer := &http.Server{
-		Addr: fmt.Sprintf(":%s", port),
-		Handler: c.Handler(
-			server.ApplyMiddlewares(
-				mux,
-			),
+	return server.CorsHandler(
+		server.ApplyMiddlewares(
+			mux,
 		),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-	}
-
-	if server.RunningServerMessage == "" {
-		server.RunningServerMessage = fmt.Sprintf("[%s] Server running on port %s\n", server.ServerName, httpServer.Addr)
-	}
-
-	fmt.Print(server.RunningServerMessage)
-	if err := httpServer.ListenAndServe(); err != nil {
-		log.Fatal(err)
-	}
-
+	)
 }
 
 // Serve set and run several Severs
@@ -163,15 +199,37 @@ func (server *Server) GroupWithOptions(group string, apiEndpoints []Endpoint, gr
 		}
 
 		if groupOptions != nil {
+			if endpoint.Options.Cors == nil {
+				endpoint.Options.Cors = groupOptions.Cors
+			}
+
 			middlewares := groupOptions.Middlewares
 
 			if len(middlewares) > 0 {
+				endpoint.checkHandlers()
 				var handler http.Handler = endpoint.HandlerFunc
+				switch {
+				case endpoint.Handler != nil:
+					handler = endpoint.Handler
+				case endpoint.HandlerServerFunc != nil:
+					handler = endpoint.HandlerServerFunc(server)
+					endpoint.HandlerServerFunc = nil
+				case endpoint.ErrorHandlerFunc != nil:
+					handler = ErrorHandler(endpoint.ErrorHandlerFunc)
+					endpoint.ErrorHandlerFunc = nil
+				case endpoint.TypedHandler != nil:
+					handler = endpoint.TypedHandler
+				case endpoint.WebSocket != nil:
+					handler = endpoint.WebSocket
+				case endpoint.Proxy != nil:
+					handler = endpoint.Proxy
+				}
 				for i := len(middlewares) - 1; i >= 0; i-- {
 					handler = middlewares[i](handler)
 				}
 				endpoint.Handler = handler
 				endpoint.HandlerFunc = nil
+				endpoint.grouped = true
 			}
 		}
 
@@ -181,3 +239,27 @@ func (server *Server) GroupWithOptions(group string, apiEndpoints []Endpoint, gr
 	server.Endpoints = append(server.Endpoints, apiEndpoints)
 
 }
+
+// checkHandlers panic when the endpoint has more than one handler, since only one of them would be served
+func (endpoint *Endpoint) checkHandlers() {
+	var kinds []string
+	for _, kind := range []struct {
+		name string
+		set  bool
+	}{
+		{"HandlerFunc", endpoint.HandlerFunc != nil},
+		{"Handler", endpoint.Handler != nil},
+		{"HandlerServerFunc", endpoint.HandlerServerFunc != nil},
+		{"ErrorHandlerFunc", endpoint.ErrorHandlerFunc != nil},
+		{"TypedHandler", endpoint.TypedHandler != nil},
+		{"WebSocket", endpoint.WebSocket != nil},
+		{"Proxy", endpoint.Proxy != nil},
+	} {
+		if kind.set {
+			kinds = append(kinds, kind.name)
+		}
+	}
+	if len(kinds) > 1 {
+		panic(fmt.Sprintf("Endpoint cannot have both %s and %s", kinds[0], kinds[1]))
+	}
+}
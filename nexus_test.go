@@ -1,8 +1,10 @@
 package nexus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -310,3 +312,61 @@ func TestServerNameWithNumber(t *testing.T) {
 		t.Fatalf("expected 'Server 3', got %s", server.ServerName)
 	}
 }
+
+func TestHandler_Idempotent(t *testing.T) {
+	server := &Server{Settings: &Settings{PathPrefix: "/api"}}
+	server.Endpoint("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server.Handler()
+	handler := server.Handler()
+	if len(server.Endpoints) != 2 {
+		t.Fatalf("expected the server endpoints added once, got %d groups", len(server.Endpoints))
+	}
+	if server.Endpoints[0][0].Path != "GET /api/users" {
+		t.Fatalf("expected the prefix applied once, got %s", server.Endpoints[0][0].Path)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/users", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+}
+
+func TestHandler_PanicsOnConflictingHandlers(t *testing.T) {
+	typed := Typed(func(ctx context.Context, in struct{}) (string, error) { return "", nil })
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		endpoint Endpoint
+		message  string
+	}{
+		{Endpoint{Path: "GET /a", HandlerFunc: handlerFunc, TypedHandler: typed}, "Endpoint cannot have both HandlerFunc and TypedHandler"},
+		{Endpoint{Path: "GET /b", Handler: http.HandlerFunc(handlerFunc), Proxy: &ProxyHandler{}}, "Endpoint cannot have both Handler and Proxy"},
+		{Endpoint{Path: "GET /c", ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request) error { return nil }, WebSocket: &WebSocketHandler{}}, "Endpoint cannot have both ErrorHandlerFunc and WebSocket"},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r != tt.message {
+					t.Errorf("%s: expected panic %q, got %v", tt.endpoint.Path, tt.message, r)
+				}
+			}()
+			server := &Server{Endpoints: [][]Endpoint{{tt.endpoint}}}
+			server.Handler()
+		}()
+	}
+
+	// The group middlewares wrap the typed handler, which is kept for OpenAPI
+	server := &Server{}
+	server.GroupWithOptions("/admin", []Endpoint{{Path: "GET /report", TypedHandler: typed}}, &GroupOptions{
+		Middlewares: []func(http.Handler) http.Handler{func(next http.Handler) http.Handler { return next }},
+	})
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/admin/report", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from the grouped typed handler, got %d", w.Code)
+	}
+}
@@ -0,0 +1,325 @@
+package nexus
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPIDocument is the OpenAPI 3.1 description generated from the server endpoints
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]OpenAPISchema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// OpenAPISchema is a JSON Schema object
+type OpenAPISchema = map[string]interface{}
+
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// OpenAPIOperation describe a single method of a path
+type OpenAPIOperation struct {
+	OperationID   string                     `json:"operationId,omitempty"`
+	Parameters    []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody   *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Security      *[]map[string][]string     `json:"security,omitempty"`
+	Responses     map[string]OpenAPIResponse `json:"responses"`
+	RequiredRoles []string                   `json:"x-required-roles,omitempty"`
+	AnyRoles      []string                   `json:"x-any-roles,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string                 `json:"name"`
+	In       string                 `json:"in"`
+	Required bool                   `json:"required"`
+	Schema   map[string]interface{} `json:"schema,omitempty"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema OpenAPISchema `json:"schema"`
+}
+
+var pathParamRegex = regexp.MustCompile(`\{([^}]+)\}`)
+
+// OpenAPI return the OpenAPI document of the registered endpoints
+func (server *Server) OpenAPI() *OpenAPIDocument {
+	document := &OpenAPIDocument{
+		OpenAPI: "3.1.0",
+		Info:    OpenAPIInfo{Title: server.ServerName, Version: "1.0.0"},
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{Schemas: make(map[string]OpenAPISchema), SecuritySchemes: map[string]OpenAPISecurityScheme{
+			"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+		}},
+	}
+
+	for _, endpoint := range server.GetEndpoints() {
+		methods, path := splitEndpointPath(endpoint.Path)
+		path = strings.Replace(path, "...}", "}", -1)
+		if path == "" {
+			continue
+		}
+
+		for _, method := range methods {
+			operation := &OpenAPIOperation{
+				OperationID:   operationID(method, path),
+				Responses:     map[string]OpenAPIResponse{"default": {Description: "Response"}},
+				RequiredRoles: endpoint.Options.RequiredRoles,
+				AnyRoles:      endpoint.Options.AnyRoles,
+			}
+			for _, match := range pathParamRegex.FindAllStringSubmatch(path, -1) {
+				operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+					Name:     match[1],
+					In:       "path",
+					Required: true,
+					Schema:   map[string]interface{}{"type": "string"},
+				})
+			}
+			operation.Security = endpointSecurity(endpoint.Options)
+			if endpoint.TypedHandler != nil {
+				describeTypedHandler(operation, endpoint.TypedHandler, document.Components.Schemas)
+			}
+
+			if document.Paths[path] == nil {
+				document.Paths[path] = make(map[string]*OpenAPIOperation)
+			}
+			document.Paths[path][method] = operation
+		}
+	}
+
+	return document
+}
+
+// describeTypedHandler add the parameters, the request body and the response of a typed handler
+func describeTypedHandler(operation *OpenAPIOperation, typed *TypedHandler, schemas map[string]OpenAPISchema) {
+	input := typed.Input
+	body := OpenAPISchema{"type": "object", "properties": OpenAPISchema{}}
+	var required []string
+	for _, field := range structFields(input) {
+		source, name := "", ""
+		for _, tag := range bindSources {
+			if value, ok := field.Tag.Lookup(tag); ok {
+				source, name = tag, value
+				if name == "" {
+					name = field.Name
+				}
+				break
+			}
+		}
+
+		if source == "" {
+			jsonName, omitempty, skip := jsonFieldName(field)
+			if skip {
+				continue
+			}
+			body["properties"].(OpenAPISchema)[jsonName] = jsonSchema(field.Type, schemas)
+			if !omitempty && field.Type.Kind() != reflect.Pointer {
+				required = append(required, jsonName)
+			}
+			continue
+		}
+
+		parameter := OpenAPIParameter{Name: name, In: source, Required: source == "path", Schema: jsonSchema(field.Type, schemas)}
+		replaced := false
+		for i := range operation.Parameters {
+			if operation.Parameters[i].In == source && operation.Parameters[i].Name == name {
+				operation.Parameters[i] = parameter
+				replaced = true
+			}
+		}
+		if !replaced && source != "path" {
+			operation.Parameters = append(operation.Parameters, parameter)
+		}
+	}
+
+	if len(body["properties"].(OpenAPISchema)) > 0 {
+		if len(required) > 0 {
+			body["required"] = required
+		}
+		operation.RequestBody = &OpenAPIRequestBody{
+			Required: len(required) > 0,
+			Content:  map[string]OpenAPIMediaType{"application/json": {Schema: body}},
+		}
+	}
+
+	status := http.StatusOK
+	if typed.Output.Kind() != reflect.Pointer && typed.Output.Implements(reflect.TypeFor[StatusCoder]()) {
+		status = reflect.Zero(typed.Output).Interface().(StatusCoder).StatusCode()
+	}
+	response := OpenAPIResponse{Description: http.StatusText(status)}
+	if status != http.StatusNoContent {
+		response.Content = map[string]OpenAPIMediaType{"application/json": {Schema: jsonSchema(typed.Output, schemas)}}
+	}
+	operation.Responses[strconv.Itoa(status)] = response
+}
+
+// structFields return the exported fields of a struct, including the fields of embedded structs with their full index
+func structFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && !hasBindTag(field) && field.Tag.Get("json") == "" {
+			for _, embedded := range structFields(field.Type) {
+				embedded.Index = append([]int{i}, embedded.Index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+		if field.IsExported() {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// jsonFieldName return the name that encoding/json uses for the field
+func jsonFieldName(field reflect.StructField) (name string, omitempty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero"), false
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// jsonSchema describe a Go type; named structs are added to the components and referenced
+func jsonSchema(t reflect.Type, schemas map[string]OpenAPISchema) OpenAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return OpenAPISchema{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return OpenAPISchema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return OpenAPISchema{"type": "string"}
+	case reflect.Bool:
+		return OpenAPISchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return OpenAPISchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return OpenAPISchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return OpenAPISchema{"type": "string", "contentEncoding": "base64"}
+		}
+		return OpenAPISchema{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return OpenAPISchema{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		ref := OpenAPISchema{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; !ok {
+			// The placeholder stops the recursion of self-referencing types
+			schemas[t.Name()] = OpenAPISchema{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+	}
+	return OpenAPISchema{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]OpenAPISchema) OpenAPISchema {
+	properties := OpenAPISchema{}
+	var required []string
+	for _, field := range structFields(t) {
+		name, omitempty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+		properties[name] = jsonSchema(field.Type, schemas)
+		if !omitempty && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := OpenAPISchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// endpointSecurity translate the scope requirements into OpenAPI security requirement objects;
+// the objects are alternatives, so every any-of scope produces one object with the all-of scopes
+func endpointSecurity(options EndpointOptions) *[]map[string][]string {
+	if options.NoRequiresAuthentication {
+		return &[]map[string][]string{}
+	}
+	if len(options.RequiredScopes) == 0 && len(options.AnyScopes) == 0 {
+		return nil
+	}
+
+	var security []map[string][]string
+	if len(options.AnyScopes) == 0 {
+		security = append(security, map[string][]string{"bearerAuth": options.RequiredScopes})
+	}
+	for _, scope := range options.AnyScopes {
+		scopes := append(append([]string{}, options.RequiredScopes...), scope)
+		security = append(security, map[string][]string{"bearerAuth": scopes})
+	}
+	return &security
+}
+
+// openAPIMethods are the operations of a pattern without method, which the ServeMux matches for every method
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch"}
+
+// splitEndpointPath split an endpoint pattern such as "GET /users/{id}" into the lower case methods and the path
+func splitEndpointPath(pattern string) ([]string, string) {
+	parts := strings.SplitN(pattern, " ", 2)
+	if len(parts) == 1 {
+		return openAPIMethods, parts[0]
+	}
+	return []string{strings.ToLower(parts[0])}, strings.TrimSpace(parts[1])
+}
+
+func operationID(method, path string) string {
+	name := strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_").Replace(path)
+	return method + strings.TrimRight(name, "_")
+}
+
+// OpenAPIHandler return the OpenAPI document of the server
+func OpenAPIHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithJSON(w, http.StatusOK, server.OpenAPI())
+	}
+}
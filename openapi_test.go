@@ -0,0 +1,108 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// --- OpenAPI ---
+
+func TestOpenAPI_PathsAndParameters(t *testing.T) {
+	server := &Server{ServerName: "Docs", EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /users/{id}"},
+		{Path: "POST /users"},
+	})
+
+	document := server.OpenAPI()
+	if document.Info.Title != "Docs" {
+		t.Fatalf("expected title Docs, got %s", document.Info.Title)
+	}
+	operation := document.Paths["/users/{id}"]["get"]
+	if operation == nil {
+		t.Fatal("expected GET /users/{id} operation")
+	}
+	if len(operation.Parameters) != 1 || operation.Parameters[0].Name != "id" || operation.Parameters[0].In != "path" {
+		t.Fatalf("unexpected parameters %+v", operation.Parameters)
+	}
+	if document.Paths["/users"]["post"] == nil {
+		t.Fatal("expected POST /users operation")
+	}
+}
+
+func TestOpenAPI_PatternWithoutMethod(t *testing.T) {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{{Path: "/webhooks/{name}"}})
+
+	operations := server.OpenAPI().Paths["/webhooks/{name}"]
+	if len(operations) != len(openAPIMethods) {
+		t.Fatalf("expected an operation for every method, got %v", operations)
+	}
+	if post := operations["post"]; post == nil || post.OperationID != "post_webhooks_name" {
+		t.Fatalf("unexpected POST operation %+v", post)
+	}
+}
+
+func TestOpenAPI_Security(t *testing.T) {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /open", Options: EndpointOptions{NoRequiresAuthentication: true}},
+		{Path: "GET /reports", Options: EndpointOptions{RequiredScopes: []string{"read"}, AnyScopes: []string{"a", "b"}, RequiredRoles: []string{"staff"}}},
+	})
+
+	document := server.OpenAPI()
+
+	open := document.Paths["/open"]["get"]
+	if open.Security == nil || len(*open.Security) != 0 {
+		t.Fatalf("expected empty security for open endpoint, got %v", open.Security)
+	}
+
+	reports := document.Paths["/reports"]["get"]
+	security := *reports.Security
+	if len(security) != 2 {
+		t.Fatalf("expected 2 security alternatives, got %v", security)
+	}
+	if scopes := security[1]["bearerAuth"]; len(scopes) != 2 || scopes[0] != "read" || scopes[1] != "b" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+	if len(reports.RequiredRoles) != 1 || reports.RequiredRoles[0] != "staff" {
+		t.Fatalf("expected x-required-roles, got %v", reports.RequiredRoles)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	server.setEndpoints([]Endpoint{
+		{Path: "GET /admin", Options: EndpointOptions{AnyRoles: []string{"admin"}}},
+	})
+
+	w := httptest.NewRecorder()
+	OpenAPIHandler(server)(w, httptest.NewRequest("GET", "/_openapi", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var document map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &document)
+	paths := document["paths"].(map[string]interface{})
+	operation := paths["/admin"].(map[string]interface{})["get"].(map[string]interface{})
+	if _, ok := operation["x-any-roles"]; !ok {
+		t.Fatalf("expected x-any-roles extension, got %v", operation)
+	}
+}
+
+func TestOpenAPIEndpoint_OptIn(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		server := &Server{ServerName: "Test", Settings: &Settings{IgnoreSecret: true, OpenAPI: enabled}}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/_openapi", nil))
+		if enabled && w.Code != http.StatusOK {
+			t.Errorf("expected 200 with Settings.OpenAPI, got %d", w.Code)
+		}
+		if !enabled && w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 without Settings.OpenAPI, got %d", w.Code)
+		}
+	}
+}
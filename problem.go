@@ -0,0 +1,168 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of RFC 9457 responses
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 error response; Extensions are serialized as top-level members
+type ProblemDetails struct {
+	Type       string         `json:"type,omitempty"`
+	Title      string         `json:"title,omitempty"`
+	Status     int            `json:"status,omitempty"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON flatten the extension members; they cannot replace the standard members
+func (p ProblemDetails) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	set := func(name, value string) {
+		if value != "" {
+			members[name] = value
+		} else {
+			delete(members, name)
+		}
+	}
+	set("type", p.Type)
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	if p.Status != 0 {
+		members["status"] = p.Status
+	} else {
+		delete(members, "status")
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON keep the unknown members as extensions
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	type standard ProblemDetails
+	if err := json.Unmarshal(data, (*standard)(p)); err != nil {
+		return err
+	}
+	var members map[string]any
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, name := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, name)
+	}
+	p.Extensions = nil
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// StatusCodeName return the snake case name of a status, e.g. "not_found" for 404
+func StatusCodeName(code int) string {
+	text := http.StatusText(code)
+	if text == "" {
+		return "unknown_error"
+	}
+	text = strings.ToLower(strings.ReplaceAll(text, "'", ""))
+	return strings.Join(strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "_")
+}
+
+// ResponseWithProblem return an application/problem+json response; the status and the title are completed
+// from each other, and the instance defaults to the request path when the ProblemDetails setting is enabled
+func ResponseWithProblem(w http.ResponseWriter, problem *ProblemDetails) error {
+	if problem == nil {
+		problem = &ProblemDetails{}
+	}
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if pw, ok := problemWriterOf(w); ok && problem.Instance == "" {
+		problem.Instance = pw.instance
+	}
+
+	response, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	w.Write(response)
+	return nil
+}
+
+// problemFromError convert the nexus error shape; code_name and errors become extension members
+func problemFromError(code int, errorResponse *ErrorResponse, typeBaseURI string) *ProblemDetails {
+	problem := &ProblemDetails{
+		Type:       "about:blank",
+		Title:      http.StatusText(code),
+		Status:     code,
+		Detail:     errorResponse.Message,
+		Extensions: map[string]any{"code_name": errorResponse.CodeName},
+	}
+	if typeBaseURI != "" && errorResponse.CodeName != "" {
+		problem.Type = strings.TrimSuffix(typeBaseURI, "/") + "/" + errorResponse.CodeName
+	}
+	if len(errorResponse.Errors) > 0 {
+		problem.Extensions["errors"] = errorResponse.Errors
+	}
+	return problem
+}
+
+// problemWriter mark the responses whose errors are sent as Problem Details
+type problemWriter struct {
+	http.ResponseWriter
+	instance    string
+	typeBaseURI string
+}
+
+func (w *problemWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *problemWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// problemWriterOf search the marker through the response writers of the middlewares
+func problemWriterOf(w http.ResponseWriter) (*problemWriter, bool) {
+	for w != nil {
+		if pw, ok := w.(*problemWriter); ok {
+			return pw, true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = unwrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// ProblemDetailsMiddleware make ResponseWithError and ResponseJsonWithError answer with RFC 9457 Problem Details;
+// it is registered by ApplyMiddlewares when Settings.ProblemDetails is enabled
+func (server *Server) ProblemDetailsMiddleware(next http.Handler) http.Handler {
+	typeBaseURI := ""
+	if server.Settings != nil {
+		typeBaseURI = server.Settings.ProblemTypeBaseURI
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemWriter{ResponseWriter: w, instance: r.URL.Path, typeBaseURI: typeBaseURI}, r)
+	})
+}
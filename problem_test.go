@@ -0,0 +1,105 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusCodeName(t *testing.T) {
+	cases := map[int]string{
+		http.StatusBadRequest:           "bad_request",
+		http.StatusNotFound:             "not_found",
+		http.StatusInternalServerError:  "internal_server_error",
+		http.StatusTeapot:               "im_a_teapot",
+		http.StatusNonAuthoritativeInfo: "non_authoritative_information",
+		799:                             "unknown_error",
+	}
+	for code, expected := range cases {
+		if got := StatusCodeName(code); got != expected {
+			t.Fatalf("%d: expected %s, got %s", code, expected, got)
+		}
+	}
+}
+
+func TestProblemDetails_JSON(t *testing.T) {
+	problem := ProblemDetails{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Status:     http.StatusForbidden,
+		Extensions: map[string]any{"balance": 30.0, "status": "ignored"},
+	}
+	data, err := json.Marshal(problem)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var members map[string]any
+	json.Unmarshal(data, &members)
+	if members["balance"] != 30.0 || members["status"] != 403.0 {
+		t.Fatalf("unexpected members %v", members)
+	}
+	if _, ok := members["detail"]; ok {
+		t.Fatal("expected empty members to be omitted")
+	}
+
+	var decoded ProblemDetails
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Status != http.StatusForbidden || decoded.Extensions["balance"] != 30.0 || len(decoded.Extensions) != 1 {
+		t.Fatalf("unexpected problem %+v", decoded)
+	}
+}
+
+func TestProblemDetailsSetting(t *testing.T) {
+	server := &Server{Settings: &Settings{ProblemDetails: true, ProblemTypeBaseURI: "https://errors.example.com/"}}
+	handler := server.ApplyMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithError(w, http.StatusNotFound, "product not found")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/products/7", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Fatalf("expected %s, got %s", ProblemContentType, ct)
+	}
+
+	var problem ProblemDetails
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if problem.Type != "https://errors.example.com/not_found" || problem.Title != "Not Found" || problem.Status != 404 {
+		t.Fatalf("unexpected problem %+v", problem)
+	}
+	if problem.Detail != "product not found" || problem.Instance != "/products/7" {
+		t.Fatalf("unexpected detail or instance %+v", problem)
+	}
+	if problem.Extensions["code_name"] != "not_found" {
+		t.Fatalf("expected code_name extension, got %v", problem.Extensions)
+	}
+	errors, _ := problem.Extensions["errors"].(map[string]any)
+	if errors["error"] != "product not found" {
+		t.Fatalf("expected errors extension, got %v", problem.Extensions)
+	}
+}
+
+func TestProblemDetailsSetting_Disabled(t *testing.T) {
+	server := &Server{Settings: &Settings{}}
+	handler := server.ApplyMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithError(w, http.StatusNotFound, "product not found")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/products/7", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json, got %s", ct)
+	}
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.CodeName != "not_found" {
+		t.Fatalf("expected not_found, got %s", resp.CodeName)
+	}
+}
@@ -0,0 +1,398 @@
+package nexus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy choose the upstream target of each request
+type BalanceStrategy int
+
+const (
+	RoundRobin       BalanceStrategy = iota // RoundRobin rotates the targets
+	LeastConnections                        // LeastConnections picks the target with fewer requests in progress
+	ConsistentHash                          // ConsistentHash sends the requests of a key to the same target while it is healthy
+)
+
+// ProxyOptions contains the configuration of a reverse proxy endpoint
+type ProxyOptions struct {
+	Targets     []string                     // Targets are the base URLs of the upstreams, e.g. http://billing:8080/v1
+	Strategy    BalanceStrategy              // Strategy is RoundRobin by default
+	HashKey     func(r *http.Request) string // HashKey is the key of ConsistentHash (default the client IP)
+	Rewrite     func(path string) string     // Rewrite changes the path sent to the upstream, after the mount has been stripped
+	Retries     int                          // Retries is the number of other targets tried when an idempotent request without body cannot reach its target
+	HealthCheck *ProxyHealthCheck            // HealthCheck enables the active health checks
+	Transport   http.RoundTripper            // Transport defaults to a clone of http.DefaultTransport
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in front of this one; the forwarded
+	// headers of other peers are replaced, since any client can send them
+	TrustedProxies []string
+	// ModifyResponse can change the responses of the upstreams; an error is answered with 502 without retrying
+	ModifyResponse func(*http.Response) error
+}
+
+// ProxyHealthCheck probes every target; the unhealthy targets receive no requests until they recover
+type ProxyHealthCheck struct {
+	Path      string        // Path is requested with GET on every target; 2xx and 3xx are healthy (default /)
+	Interval  time.Duration // Interval between the checks (default 10s)
+	Timeout   time.Duration // Timeout of each check (default 2s)
+	Failures  int           // Failures are the consecutive failed checks that make a target unhealthy (default 2)
+	Successes int           // Successes are the consecutive passed checks that make it healthy again (default 1)
+}
+
+// ProxyHandler forward the requests of an endpoint to the upstream targets
+type ProxyHandler struct {
+	options   ProxyOptions
+	targets   []*proxyTarget
+	trusted   []netip.Prefix
+	transport http.RoundTripper
+	next      atomic.Uint64
+	check     *ProxyHealthCheck // check is the HealthCheck with the defaults
+	startOnce sync.Once
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type proxyTarget struct {
+	url       *url.URL
+	healthy   atomic.Bool
+	active    atomic.Int64
+	failures  int // failures and successes are only used by the health check goroutine
+	successes int
+}
+
+// Proxy create the handler of a reverse proxy endpoint; it panics when a target is not an absolute URL.
+// The endpoint path is stripped up to its {name...} wildcard, which also strips Settings.PathPrefix and
+// the group path, and the X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and Forwarded headers
+// describe the client, so that RequestScheme on the upstream returns its scheme:
+//
+//	billing := nexus.Proxy(nexus.ProxyOptions{
+//		Targets:     []string{"http://billing-1:8080/v1", "http://billing-2:8080/v1"},
+//		Strategy:    nexus.LeastConnections,
+//		Retries:     1,
+//		HealthCheck: &nexus.ProxyHealthCheck{Path: "/health"},
+//	})
+//	server.Group("/billing", []nexus.Endpoint{
+//		{Path: "GET /{path...}", Proxy: billing},
+//		{Path: "POST /{path...}", Proxy: billing},
+//	})
+func Proxy(options ProxyOptions) *ProxyHandler {
+	if len(options.Targets) == 0 {
+		panic("nexus: Proxy needs at least one target")
+	}
+	p := &ProxyHandler{options: options, transport: options.Transport, stop: make(chan struct{})}
+	if p.transport == nil {
+		p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	for _, raw := range options.Targets {
+		target, err := url.Parse(raw)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			panic(fmt.Sprintf("nexus: invalid proxy target %q", raw))
+		}
+		t := &proxyTarget{url: target}
+		t.healthy.Store(true)
+		p.targets = append(p.targets, t)
+	}
+	for _, raw := range options.TrustedProxies {
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(raw)
+			if addrErr != nil {
+				panic(fmt.Sprintf("nexus: invalid trusted proxy %q", raw))
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+
+	if options.HealthCheck != nil {
+		check := *options.HealthCheck
+		if check.Path == "" {
+			check.Path = "/"
+		}
+		if check.Interval == 0 {
+			check.Interval = 10 * time.Second
+		}
+		if check.Timeout == 0 {
+			check.Timeout = 2 * time.Second
+		}
+		if check.Failures == 0 {
+			check.Failures = 2
+		}
+		if check.Successes == 0 {
+			check.Successes = 1
+		}
+		p.check = &check
+	}
+	return p
+}
+
+// Close stop the health checks; it is called when the http.Server that serves the proxy shuts down
+func (p *ProxyHandler) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// startHealthChecks run the health checks from the first request, so a handler that is never served
+// leaves no goroutine behind, and stop them with the server of the request
+func (p *ProxyHandler) startHealthChecks(r *http.Request) {
+	if p.check == nil {
+		return
+	}
+	p.startOnce.Do(func() {
+		if httpServer, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok {
+			httpServer.RegisterOnShutdown(p.Close)
+		}
+		go p.healthChecks(*p.check)
+	})
+}
+
+func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.startHealthChecks(r)
+	attempts := 1
+	if retryable(r) {
+		attempts += p.options.Retries
+	}
+	path := p.upstreamPath(r)
+
+	tried := make(map[*proxyTarget]bool)
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		target := p.pick(r, tried)
+		if target == nil {
+			break
+		}
+		tried[target] = true
+
+		var failed error
+		var rejected bool
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.Out.URL.Path = path
+				pr.Out.URL.RawPath = ""
+				pr.SetURL(target.url)
+				setForwardedHeaders(pr.In, pr.Out, p.trustedPeer(pr.In))
+			},
+			Transport: p.transport,
+			// The errors happen before the response is written, so another target can still answer
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				failed = err
+			},
+		}
+		if p.options.ModifyResponse != nil {
+			proxy.ModifyResponse = func(resp *http.Response) error {
+				err := p.options.ModifyResponse(resp)
+				rejected = err != nil
+				return err
+			}
+		}
+
+		// ReverseProxy panics with http.ErrAbortHandler when the response cannot be copied
+		func() {
+			target.active.Add(1)
+			defer target.active.Add(-1)
+			proxy.ServeHTTP(w, r)
+		}()
+		if failed == nil {
+			return
+		}
+		if rejected {
+			// The upstream has processed the request, it is not sent to another target
+			ResponseWithError(w, http.StatusBadGateway, "the upstream response was rejected")
+			return
+		}
+		lastErr = failed
+		if r.Context().Err() != nil {
+			// The client is gone
+			return
+		}
+	}
+
+	switch {
+	case lastErr == nil:
+		ResponseWithError(w, http.StatusServiceUnavailable, "no upstream is available")
+	case errors.Is(lastErr, context.DeadlineExceeded):
+		ResponseWithError(w, http.StatusGatewayTimeout, "the upstream did not answer in time")
+	default:
+		ResponseWithError(w, http.StatusBadGateway, "the upstream could not be reached")
+	}
+}
+
+// upstreamPath strip the endpoint path up to its {name...} wildcard, then apply Rewrite
+func (p *ProxyHandler) upstreamPath(r *http.Request) string {
+	path := r.URL.Path
+	if match := wildcardRegex.FindStringSubmatch(r.Pattern); match != nil {
+		path = "/" + r.PathValue(match[1])
+	}
+	if p.options.Rewrite != nil {
+		path = p.options.Rewrite(path)
+	}
+	return path
+}
+
+// retryable evaluate if a request can be sent again: only idempotent methods without body are retried
+func retryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 && r.Header.Get("Transfer-Encoding") == ""
+	}
+	return false
+}
+
+// pick choose a healthy target that has not been tried
+func (p *ProxyHandler) pick(r *http.Request, tried map[*proxyTarget]bool) *proxyTarget {
+	var candidates []*proxyTarget
+	for _, target := range p.targets {
+		if target.healthy.Load() && !tried[target] {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.options.Strategy {
+	case LeastConnections:
+		// The rotation spreads the ties
+		start := int(p.next.Add(1) % uint64(len(candidates)))
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			candidate := candidates[(start+i)%len(candidates)]
+			if candidate.active.Load() < best.active.Load() {
+				best = candidate
+			}
+		}
+		return best
+	case ConsistentHash:
+		// Rendezvous hashing only moves the keys of a target that leaves
+		key := clientIP(r)
+		if p.options.HashKey != nil {
+			key = p.options.HashKey(r)
+		}
+		var best *proxyTarget
+		var bestScore uint64
+		for _, candidate := range candidates {
+			h := fnv.New64a()
+			h.Write([]byte(key))
+			h.Write([]byte(candidate.url.String()))
+			if score := h.Sum64(); best == nil || score > bestScore {
+				best, bestScore = candidate, score
+			}
+		}
+		return best
+	}
+	return candidates[(p.next.Add(1)-1)%uint64(len(candidates))]
+}
+
+func (p *ProxyHandler) healthChecks(check ProxyHealthCheck) {
+	client := &http.Client{
+		Transport: p.transport,
+		Timeout:   check.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	for {
+		for _, target := range p.targets {
+			p.checkTarget(client, target, check)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ProxyHandler) checkTarget(client *http.Client, target *proxyTarget, check ProxyHealthCheck) {
+	healthURL := target.url.JoinPath(check.Path)
+	passed := false
+	if resp, err := client.Get(healthURL.String()); err == nil {
+		resp.Body.Close()
+		passed = resp.StatusCode >= 200 && resp.StatusCode < 400
+	}
+
+	if passed {
+		target.failures = 0
+		target.successes++
+		if target.successes >= check.Successes {
+			target.healthy.Store(true)
+		}
+		return
+	}
+	target.successes = 0
+	target.failures++
+	if target.failures >= check.Failures {
+		target.healthy.Store(false)
+	}
+}
+
+// trustedPeer evaluate if the peer of the request is one of the TrustedProxies
+func (p *ProxyHandler) trustedPeer(r *http.Request) bool {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwardedHeaders describe the client in the forwarded headers; the headers of a trusted peer are
+// extended, so their first values keep describing the original client, and the others are replaced
+func setForwardedHeaders(in *http.Request, out *http.Request, trusted bool) {
+	ip := clientIP(in)
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	node := ip
+	if strings.Contains(ip, ":") {
+		// IPv6 addresses are quoted and bracketed (RFC 7239 section 6)
+		node = `"[` + ip + `]"`
+	}
+	element := fmt.Sprintf(`for=%s;host="%s";proto=%s`, node, in.Host, proto)
+
+	if !trusted {
+		out.Header.Set("X-Forwarded-For", ip)
+		out.Header.Set("X-Forwarded-Host", in.Host)
+		out.Header.Set("X-Forwarded-Proto", proto)
+		out.Header.Set("Forwarded", element)
+		return
+	}
+
+	out.Header.Set("X-Forwarded-For", strings.Join(append(in.Header.Values("X-Forwarded-For"), ip), ", "))
+	host := in.Host
+	if first := in.Header.Get("X-Forwarded-Host"); first != "" {
+		host, _, _ = strings.Cut(first, ",")
+		host = strings.TrimSpace(host)
+	}
+	out.Header.Set("X-Forwarded-Host", host)
+	out.Header.Set("X-Forwarded-Proto", RequestScheme(in))
+	out.Header.Set("Forwarded", strings.Join(append(in.Header.Values("Forwarded"), element), ", "))
+}
+
+// clientIP return the address of the peer of the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
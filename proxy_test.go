@@ -0,0 +1,332 @@
+package nexus
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// upstream answer with its name and the request it received
+func upstream(t *testing.T, name string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ResponseWithJSON(w, http.StatusOK, map[string]string{
+			"upstream":  name,
+			"path":      r.URL.Path,
+			"query":     r.URL.RawQuery,
+			"scheme":    RequestScheme(r),
+			"for":       r.Header.Get("X-Forwarded-For"),
+			"host":      r.Header.Get("X-Forwarded-Host"),
+			"forwarded": r.Header.Get("Forwarded"),
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func proxyGet(t *testing.T, handler http.Handler, r *http.Request) (int, map[string]string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body
+}
+
+// closedAddr return the URL of a port that refuses the connections
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func TestProxy_RoundRobinAndPaths(t *testing.T) {
+	a, b := upstream(t, "a"), upstream(t, "b")
+	billing := Proxy(ProxyOptions{Targets: []string{a.URL + "/v1", b.URL + "/v1"}})
+
+	server := &Server{ServerName: "Gateway", Settings: &Settings{PathPrefix: "/api"}}
+	server.Group("/billing", []Endpoint{
+		{Path: "GET /{path...}", Proxy: billing, Options: EndpointOptions{IsPublic: true}},
+	})
+	handler := server.Handler()
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		status, body := proxyGet(t, handler, httptest.NewRequest("GET", "/api/billing/invoices/7?expand=lines", nil))
+		if status != http.StatusOK {
+			t.Fatalf("status = %d", status)
+		}
+		if body["path"] != "/v1/invoices/7" || body["query"] != "expand=lines" {
+			t.Errorf("upstream request = %s?%s", body["path"], body["query"])
+		}
+		seen[body["upstream"]]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("round robin = %v", seen)
+	}
+
+	rewritten := Proxy(ProxyOptions{Targets: []string{a.URL}, Rewrite: func(path string) string { return "/legacy" + path }})
+	server = &Server{ServerName: "Gateway"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{{Path: "GET /old/{rest...}", Proxy: rewritten, Options: EndpointOptions{IsPublic: true}}})
+	if _, body := proxyGet(t, server.Handler(), httptest.NewRequest("GET", "/old/users", nil)); body["path"] != "/legacy/users" {
+		t.Errorf("rewritten path = %q", body["path"])
+	}
+}
+
+func TestProxy_ForwardedHeaders(t *testing.T) {
+	a := upstream(t, "a")
+	handler := Proxy(ProxyOptions{Targets: []string{a.URL}, TrustedProxies: []string{"10.0.0.0/8"}})
+
+	// A client behind another TLS proxy
+	r := httptest.NewRequest("GET", "http://gateway.local/reports", nil)
+	r.RemoteAddr = "10.0.0.2:41000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	r.Header.Set("X-Forwarded-Proto", "HTTPS")
+	r.Header.Set("X-Forwarded-Host", "api.example.com")
+	r.Header.Set("Forwarded", `for=203.0.113.9;proto=https;host="api.example.com"`)
+	_, body := proxyGet(t, handler, r)
+	if body["scheme"] != "https" {
+		t.Errorf("scheme = %q", body["scheme"])
+	}
+	if body["for"] != "203.0.113.9, 10.0.0.2" || body["host"] != "api.example.com" {
+		t.Errorf("X-Forwarded-For = %q, X-Forwarded-Host = %q", body["for"], body["host"])
+	}
+	if !strings.HasPrefix(body["forwarded"], `for=203.0.113.9;proto=https;host="api.example.com", for=10.0.0.2;`) {
+		t.Errorf("Forwarded = %q", body["forwarded"])
+	}
+
+	// A plain http client that forges the headers
+	r = httptest.NewRequest("GET", "http://gateway.local/reports", nil)
+	r.RemoteAddr = "198.51.100.7:41000"
+	r.Header.Set("X-Forwarded-For", "127.0.0.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "admin.example.com")
+	r.Header.Set("Forwarded", "for=127.0.0.1;proto=https")
+	_, body = proxyGet(t, handler, r)
+	if body["scheme"] != "http" || body["for"] != "198.51.100.7" || body["host"] != "gateway.local" {
+		t.Errorf("forged headers must be replaced: scheme = %q, X-Forwarded-For = %q, X-Forwarded-Host = %q", body["scheme"], body["for"], body["host"])
+	}
+	if body["forwarded"] != `for=198.51.100.7;host="gateway.local";proto=http` {
+		t.Errorf("Forwarded = %q", body["forwarded"])
+	}
+
+	// A direct IPv6 client
+	r = httptest.NewRequest("GET", "http://gateway.local/reports", nil)
+	r.RemoteAddr = "[2001:db8::1]:41000"
+	_, body = proxyGet(t, handler, r)
+	if body["scheme"] != "http" || body["forwarded"] != `for="[2001:db8::1]";host="gateway.local";proto=http` {
+		t.Errorf("scheme = %q, Forwarded = %q", body["scheme"], body["forwarded"])
+	}
+}
+
+func TestProxy_LeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "slow")
+	}))
+	defer slow.Close()
+	fast := upstream(t, "fast")
+	handler := Proxy(ProxyOptions{Targets: []string{slow.URL, fast.URL}, Strategy: LeastConnections})
+
+	// Hold a request on one of the targets
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Body.String() == "slow" {
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.targets[0].active.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, body := proxyGet(t, handler, httptest.NewRequest("GET", "/", nil)); body["upstream"] != "fast" {
+			t.Errorf("request %d went to %q, want the idle target", i, body["upstream"])
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestProxy_AbortedResponse(t *testing.T) {
+	// The upstream dies in the middle of the body
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer broken.Close()
+	handler := Proxy(ProxyOptions{Targets: []string{broken.URL}, Strategy: LeastConnections})
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for handler.targets[0].active.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("active = %d after the aborted response", handler.targets[0].active.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProxy_ConsistentHash(t *testing.T) {
+	a, b, c := upstream(t, "a"), upstream(t, "b"), upstream(t, "c")
+	handler := Proxy(ProxyOptions{
+		Targets:  []string{a.URL, b.URL, c.URL},
+		Strategy: ConsistentHash,
+		HashKey:  func(r *http.Request) string { return r.Header.Get("X-Tenant") },
+	})
+
+	assigned := map[string]string{}
+	for _, tenant := range []string{"acme", "globex", "initech", "umbrella", "hooli"} {
+		for i := 0; i < 3; i++ {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Tenant", tenant)
+			_, body := proxyGet(t, handler, r)
+			if previous, ok := assigned[tenant]; ok && previous != body["upstream"] {
+				t.Errorf("%s moved from %s to %s", tenant, previous, body["upstream"])
+			}
+			assigned[tenant] = body["upstream"]
+		}
+	}
+
+	// Only the keys of a target that leaves are moved
+	handler.targets[0].healthy.Store(false)
+	for tenant, previous := range assigned {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		if _, body := proxyGet(t, handler, r); previous != "a" && body["upstream"] != previous {
+			t.Errorf("%s moved from %s to %s", tenant, previous, body["upstream"])
+		}
+	}
+}
+
+func TestProxy_HealthCheck(t *testing.T) {
+	var mu sync.Mutex
+	failing := true
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ResponseWithJSON(w, http.StatusOK, map[string]string{"upstream": "sick"})
+	}))
+	defer sick.Close()
+	healthy := upstream(t, "healthy")
+
+	handler := Proxy(ProxyOptions{
+		Targets:     []string{sick.URL, healthy.URL},
+		HealthCheck: &ProxyHealthCheck{Path: "/health", Interval: 10 * time.Millisecond, Failures: 1},
+	})
+	defer handler.Close()
+
+	waitHealthy := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for handler.targets[0].healthy.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("healthy = %v, want %v", !want, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// The checks start with the first request
+	time.Sleep(30 * time.Millisecond)
+	if !handler.targets[0].healthy.Load() {
+		t.Fatal("the health checks must not run before the first request")
+	}
+	proxyGet(t, handler, httptest.NewRequest("GET", "/", nil))
+	waitHealthy(false)
+	for i := 0; i < 4; i++ {
+		if _, body := proxyGet(t, handler, httptest.NewRequest("GET", "/", nil)); body["upstream"] != "healthy" {
+			t.Errorf("request %d went to %q", i, body["upstream"])
+		}
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	waitHealthy(true)
+}
+
+func TestProxy_Retries(t *testing.T) {
+	a := upstream(t, "a")
+	handler := Proxy(ProxyOptions{Targets: []string{closedAddr(t), a.URL}, Retries: 1})
+
+	// The round robin starts with the closed target
+	if status, body := proxyGet(t, handler, httptest.NewRequest("GET", "/", nil)); status != http.StatusOK || body["upstream"] != "a" {
+		t.Errorf("GET: status = %d, upstream = %q", status, body["upstream"])
+	}
+
+	handler = Proxy(ProxyOptions{Targets: []string{closedAddr(t), a.URL}, Retries: 1})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"amount":10}`)))
+	var body ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusBadGateway || body.CodeName != "bad_gateway" {
+		t.Errorf("POST must not be retried: status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProxy_ModifyResponseError(t *testing.T) {
+	var calls atomic.Int32
+	count := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	})
+	a, b := httptest.NewServer(count), httptest.NewServer(count)
+	defer a.Close()
+	defer b.Close()
+	handler := Proxy(ProxyOptions{
+		Targets:        []string{a.URL, b.URL},
+		Retries:        1,
+		ModifyResponse: func(*http.Response) error { return errors.New("rejected") },
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", w.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("the upstreams received %d requests, want 1", calls.Load())
+	}
+}
+
+func TestProxy_NoHealthyTarget(t *testing.T) {
+	handler := Proxy(ProxyOptions{Targets: []string{"http://127.0.0.1:1"}})
+	handler.targets[0].healthy.Store(false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,321 @@
+package nexus
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupportedPayload is returned by encoders that cannot represent a payload, e.g. CSV for a single number;
+// Respond then tries the next acceptable encoder
+var ErrUnsupportedPayload = errors.New("the payload cannot be encoded in this format")
+
+// ResponseEncoder write payloads in a media type
+type ResponseEncoder struct {
+	Format    string // Format is the value of the ?format= parameter, e.g. "csv"
+	MediaType string // MediaType is sent as Content-Type and matched against Accept
+	Encode    func(w io.Writer, payload any) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = []ResponseEncoder{
+		{Format: "json", MediaType: "application/json", Encode: encodeJSON},
+		{Format: "xml", MediaType: "application/xml", Encode: encodeXML},
+		{Format: "csv", MediaType: "text/csv", Encode: encodeCSV},
+		{Format: "msgpack", MediaType: "application/msgpack", Encode: EncodeMsgPack},
+	}
+)
+
+// RegisterEncoder add an encoder, or replace the encoder of the same format; JSON is used when the client
+// has no preference
+func RegisterEncoder(encoder ResponseEncoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	for i := range encoders {
+		if encoders[i].Format == encoder.Format {
+			encoders[i] = encoder
+			return
+		}
+	}
+	encoders = append(encoders, encoder)
+}
+
+// Respond write the payload with the encoder chosen by the ?format= parameter or the Accept header,
+// answering 406 Not Acceptable when no registered encoder fits
+func Respond(w http.ResponseWriter, r *http.Request, code int, payload any) error {
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	w.Header().Add("Vary", "Accept")
+
+	var body bytes.Buffer
+	for _, encoder := range negotiateEncoders(r) {
+		body.Reset()
+		err := encoder.Encode(&body, payload)
+		if errors.Is(err, ErrUnsupportedPayload) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		contentType := encoder.MediaType
+		if strings.HasPrefix(contentType, "text/") {
+			contentType += "; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(code)
+		w.Write(body.Bytes())
+		return nil
+	}
+
+	return ResponseJsonWithError(w, http.StatusNotAcceptable, &ErrorResponse{
+		Code:     http.StatusNotAcceptable,
+		Message:  "none of the acceptable media types is available",
+		CodeName: "not_acceptable",
+		Errors:   map[string]string{"accept": strings.Join(availableMediaTypes(), ", ")},
+	})
+}
+
+// negotiateEncoders return the acceptable encoders in order of preference
+func negotiateEncoders(r *http.Request) []ResponseEncoder {
+	encodersMu.RLock()
+	registered := append([]ResponseEncoder{}, encoders...)
+	encodersMu.RUnlock()
+
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, encoder := range registered {
+			if strings.EqualFold(encoder.Format, format) {
+				return []ResponseEncoder{encoder}
+			}
+		}
+		return nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return registered
+	}
+
+	type candidate struct {
+		encoder     ResponseEncoder
+		q           float64
+		specificity int
+		order       int
+	}
+	var candidates []candidate
+	for i, encoder := range registered {
+		q, specificity := acceptQuality(accept, encoder.MediaType)
+		if q > 0 {
+			candidates = append(candidates, candidate{encoder, q, specificity, i})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		if candidates[i].specificity != candidates[j].specificity {
+			return candidates[i].specificity > candidates[j].specificity
+		}
+		return candidates[i].order < candidates[j].order
+	})
+
+	result := make([]ResponseEncoder, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.encoder
+	}
+	return result
+}
+
+// acceptQuality return the q-value that the most specific matching range of an Accept header gives to a media type
+func acceptQuality(accept string, mediaType string) (float64, int) {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		level := -1
+		switch {
+		case accepted == mediaType:
+			level = 2
+		case accepted == typ+"/*":
+			level = 1
+		case accepted == "*/*":
+			level = 0
+		}
+		if level <= specificity {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		quality, specificity = q, level
+	}
+	return quality, specificity
+}
+
+func availableMediaTypes() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	types := make([]string, len(encoders))
+	for i, encoder := range encoders {
+		types[i] = encoder.MediaType
+	}
+	return types
+}
+
+func encodeJSON(w io.Writer, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// xmlList is the root element of the slices, which have none of their own
+type xmlList struct {
+	XMLName xml.Name `xml:"response"`
+	Items   any      `xml:"item"`
+}
+
+func encodeXML(w io.Writer, payload any) error {
+	value := reflect.ValueOf(payload)
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		payload = xmlList{Items: payload}
+	}
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(payload); err != nil {
+		var unsupported *xml.UnsupportedTypeError
+		if errors.As(err, &unsupported) {
+			return ErrUnsupportedPayload
+		}
+		return err
+	}
+	return nil
+}
+
+// encodeCSV write a slice of structs, or a single struct, with a header of the JSON names of the fields;
+// the data of a ResponsePagination is written without the pagination
+func encodeCSV(w io.Writer, payload any) error {
+	switch paginated := payload.(type) {
+	case ResponsePagination:
+		payload = paginated.Data
+	case *ResponsePagination:
+		payload = paginated.Data
+	}
+
+	rows := reflect.ValueOf(payload)
+	for rows.Kind() == reflect.Pointer || rows.Kind() == reflect.Interface {
+		if rows.IsNil() {
+			return ErrUnsupportedPayload
+		}
+		rows = rows.Elem()
+	}
+	if rows.Kind() == reflect.Struct {
+		rows = reflect.ValueOf([]any{rows.Interface()})
+	}
+	if rows.Kind() != reflect.Slice && rows.Kind() != reflect.Array {
+		return ErrUnsupportedPayload
+	}
+
+	elemType := rows.Type().Elem()
+	if rows.Len() > 0 {
+		elemType = reflect.Indirect(reflect.ValueOf(rows.Index(0).Interface())).Type()
+	}
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct || elemType == timeType {
+		return ErrUnsupportedPayload
+	}
+
+	fields := structFields(elemType)
+	var header []string
+	var columns []reflect.StructField
+	for _, field := range fields {
+		name, _, skip := jsonFieldName(field)
+		if !skip {
+			header = append(header, name)
+			columns = append(columns, field)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for i := 0; i < rows.Len(); i++ {
+		row := reflect.Indirect(reflect.ValueOf(rows.Index(i).Interface()))
+		if !row.IsValid() || row.Type() != elemType {
+			return ErrUnsupportedPayload
+		}
+		record := make([]string, len(columns))
+		for j, field := range columns {
+			record[j] = csvValue(row.FieldByIndex(field.Index))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvValue(value reflect.Value) string {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	if value.CanInterface() {
+		if t, ok := value.Interface().(time.Time); ok {
+			return t.Format(time.RFC3339)
+		}
+		if marshaler, ok := value.Interface().(encoding.TextMarshaler); ok {
+			text, _ := marshaler.MarshalText()
+			return csvText(string(text))
+		}
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return csvText(value.String())
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, value.Type().Bits())
+	}
+	if !value.CanInterface() || ((value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.IsNil()) {
+		return ""
+	}
+	// Nested values are kept as JSON in a single cell
+	data, _ := json.Marshal(value.Interface())
+	return string(data)
+}
+
+// csvText prefix with a quote the text that a spreadsheet would run as a formula
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
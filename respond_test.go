@@ -0,0 +1,149 @@
+package nexus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type product struct {
+	ID      int        `json:"id" xml:"id"`
+	Name    string     `json:"name" xml:"name"`
+	Price   float64    `json:"price" xml:"price"`
+	Tags    []string   `json:"tags,omitempty" xml:"tag"`
+	Updated *time.Time `json:"updated" xml:"updated,omitempty"`
+}
+
+var products = []product{{ID: 1, Name: "Pen", Price: 1.5}, {ID: 2, Name: "Ink, blue", Price: 3, Tags: []string{"new"}}}
+
+func respond(t *testing.T, target, accept string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", target, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	if err := Respond(w, r, http.StatusOK, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return w
+}
+
+func TestRespond_Negotiation(t *testing.T) {
+	cases := []struct {
+		target, accept, contentType string
+	}{
+		{"/products", "", "application/json"},
+		{"/products", "application/xml", "application/xml"},
+		{"/products", "text/html, application/*;q=0.9, */*;q=0.1", "application/json"},
+		{"/products", "application/json;q=0.5, text/csv", "text/csv; charset=utf-8"},
+		{"/products", "application/*, application/json;q=0", "application/xml"},
+		{"/products?format=msgpack", "application/json", "application/msgpack"},
+	}
+	for _, c := range cases {
+		w := respond(t, c.target, c.accept, products)
+		if got := w.Header().Get("Content-Type"); got != c.contentType {
+			t.Fatalf("%s %q: expected %s, got %s", c.target, c.accept, c.contentType, got)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Fatal("expected Vary: Accept")
+		}
+	}
+}
+
+func TestRespond_NotAcceptable(t *testing.T) {
+	for _, c := range []struct{ target, accept string }{
+		{"/products", "image/png"},
+		{"/products?format=yaml", ""},
+	} {
+		w := respond(t, c.target, c.accept, products)
+		if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), "not_acceptable") {
+			t.Fatalf("expected 406, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	// CSV cannot encode a number, so the next acceptable encoder is used
+	w := respond(t, "/count", "text/csv, application/json;q=0.1", 42)
+	if w.Header().Get("Content-Type") != "application/json" || w.Body.String() != "42" {
+		t.Fatalf("expected the JSON fallback, got %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestRespond_CSV(t *testing.T) {
+	w := respond(t, "/products", "text/csv", &ResponsePagination{Data: products, Total: 2})
+	expected := "id,name,price,tags,updated\n1,Pen,1.5,,\n2,\"Ink, blue\",3,\"[\"\"new\"\"]\",\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected CSV:\n%s", w.Body.String())
+	}
+
+	// The formulas are written as text, the negative numbers are kept
+	w = respond(t, "/products", "text/csv", []product{{ID: -1, Name: "=HYPERLINK(\"x\")", Price: -2}, {Name: "@SUM(A1)"}, {Name: "+1"}, {Name: "-1"}})
+	expected = "id,name,price,tags,updated\n-1,\"'=HYPERLINK(\"\"x\"\")\",-2,,\n0,'@SUM(A1),0,,\n0,'+1,0,,\n0,'-1,0,,\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected CSV:\n%s", w.Body.String())
+	}
+}
+
+func TestRespond_XML(t *testing.T) {
+	w := respond(t, "/products", "application/xml", products[:1])
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<response><item><id>1</id><name>Pen</name><price>1.5</price></item></response>`
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected XML:\n%s", w.Body.String())
+	}
+}
+
+func TestEncodeMsgPack(t *testing.T) {
+	cases := []struct {
+		payload  any
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{7, []byte{0x07}},
+		{-1, []byte{0xff}},
+		{-100, []byte{0xd0, 0x9c}},
+		{300, []byte{0xcd, 0x01, 0x2c}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"hi", []byte{0xa2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{product{ID: 1, Name: "P"}, []byte{0x84,
+			0xa2, 'i', 'd', 0x01,
+			0xa4, 'n', 'a', 'm', 'e', 0xa1, 'P',
+			0xa5, 'p', 'r', 'i', 'c', 'e', 0xcb, 0, 0, 0, 0, 0, 0, 0, 0,
+			0xa7, 'u', 'p', 'd', 'a', 't', 'e', 'd', 0xc0}},
+		{strings.Repeat("x", 40), append([]byte{0xd9, 40}, strings.Repeat("x", 40)...)},
+		{json.RawMessage(`[true]`), []byte{0x91, 0xc3}},
+		{ProblemDetails{Title: "T", Extensions: map[string]any{"code": "x"}}, []byte{0x82,
+			0xa4, 'c', 'o', 'd', 'e', 0xa1, 'x',
+			0xa5, 't', 'i', 't', 'l', 'e', 0xa1, 'T'}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := EncodeMsgPack(&buf, c.payload); err != nil {
+			t.Fatalf("%v: unexpected error %v", c.payload, err)
+		}
+		if !bytes.Equal(buf.Bytes(), c.expected) {
+			t.Fatalf("%v: expected % x, got % x", c.payload, c.expected, buf.Bytes())
+		}
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder(ResponseEncoder{Format: "test", MediaType: "text/x-test", Encode: func(w io.Writer, payload any) error {
+		_, err := fmt.Fprintf(w, "%v", payload)
+		return err
+	}})
+
+	w := respond(t, "/products", "text/x-test", "plain")
+	if w.Header().Get("Content-Type") != "text/x-test; charset=utf-8" || w.Body.String() != "plain" {
+		t.Fatalf("unexpected response %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
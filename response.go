@@ -52,7 +52,7 @@ func ResponseWithError(w http.ResponseWriter, code int, msg string) error {
 	errorResponse := &ErrorResponse{
 		Code:     code,
 		Message:  msg,
-		CodeName: "internal_server_error",
+		CodeName: StatusCodeName(code),
 		Errors:   map[string]string{"error": msg},
 	}
 	return ResponseJsonWithError(w, code, errorResponse)
@@ -65,6 +65,8 @@ type ErrorResponse struct {
 	Errors   map[string]string `json:"errors"`
 }
 
+// ResponseJsonWithError return a json response with the error; when the ProblemDetails setting is enabled
+// it is sent as RFC 9457 Problem Details
 func ResponseJsonWithError(w http.ResponseWriter, code int, errorResponse *ErrorResponse) error {
 	if code == 0 {
 		code = http.StatusInternalServerError
@@ -82,6 +84,11 @@ func ResponseJsonWithError(w http.ResponseWriter, code int, errorResponse *Error
 		errorResponse.Message = "BODY_REQUIRED"
 	}
 
+	// With the ProblemDetails setting the error is sent as application/problem+json
+	if pw, ok := problemWriterOf(w); ok {
+		return ResponseWithProblem(w, problemFromError(code, errorResponse, pw.typeBaseURI))
+	}
+
 	return ResponseWithJSON(w, code, errorResponse)
 }
 
@@ -74,8 +74,8 @@ func TestResponseWithError(t *testing.T) {
 	if resp.Message != "bad input" {
 		t.Fatalf("expected 'bad input', got %s", resp.Message)
 	}
-	if resp.CodeName != "internal_server_error" {
-		t.Fatalf("expected 'internal_server_error', got %s", resp.CodeName)
+	if resp.CodeName != "bad_request" {
+		t.Fatalf("expected 'bad_request', got %s", resp.CodeName)
 	}
 	if resp.Errors["error"] != "bad input" {
 		t.Fatalf("expected error map entry, got %v", resp.Errors)
@@ -0,0 +1,89 @@
+package nexus
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"time"
+)
+
+const secretLabelContextKey contextKey = "nexus.secret_label"
+
+// SecretKey is an API secret accepted by the ValidateSecret middleware
+type SecretKey struct {
+	Label     string    // Label identifies the key in the request context, e.g. the client or the rotation
+	Value     string    // Value is the secret itself
+	ExpiresAt time.Time // ExpiresAt is the moment the key stops being accepted; zero never expires
+	Scopes    []string  // Scopes granted to the requests authenticated with the key
+}
+
+// SecretProvider return the secrets that are currently accepted; several keys can be active at the same time,
+// so a new secret can be rolled out before the old one is retired
+type SecretProvider interface {
+	Secrets() []SecretKey
+}
+
+// StaticSecrets is a SecretProvider backed by a fixed list of keys
+type StaticSecrets []SecretKey
+
+func (s StaticSecrets) Secrets() []SecretKey {
+	return s
+}
+
+// SecretLabelFromContext return the label of the secret that authenticated the request
+func SecretLabelFromContext(ctx context.Context) (string, bool) {
+	label, ok := ctx.Value(secretLabelContextKey).(string)
+	return label, ok
+}
+
+// secretKeys return the keys of the provider plus the legacy Server.Secret
+func (server *Server) secretKeys() []SecretKey {
+	var keys []SecretKey
+	if server.Secret != "" {
+		keys = append(keys, SecretKey{Label: "default", Value: server.Secret})
+	}
+	if server.SecretProvider != nil {
+		keys = append(keys, server.SecretProvider.Secrets()...)
+	}
+	return keys
+}
+
+// requestSecret read the secret from the configured header, falling back to the query parameter when enabled
+func (server *Server) requestSecret(r *http.Request) string {
+	header := "x-secret"
+	query := ""
+	if server.Settings != nil {
+		if server.Settings.SecretHeader != "" {
+			header = server.Settings.SecretHeader
+		}
+		query = server.Settings.SecretQueryParam
+	}
+
+	if secret := r.Header.Get(header); secret != "" {
+		return secret
+	}
+	if query != "" {
+		return r.URL.Query().Get(query)
+	}
+	return ""
+}
+
+// matchSecret compare the secret with every active key in constant time; all keys are always compared
+// so the response time does not reveal which key matched
+func matchSecret(keys []SecretKey, secret string, now time.Time) (SecretKey, bool) {
+	var matched SecretKey
+	found := false
+
+	given := sha256.Sum256([]byte(secret))
+	for _, key := range keys {
+		expected := sha256.Sum256([]byte(key.Value))
+		equal := subtle.ConstantTimeCompare(given[:], expected[:]) == 1
+		active := key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt)
+		if equal && active && key.Value != "" && !found {
+			matched = key
+			found = true
+		}
+	}
+	return matched, found
+}
@@ -0,0 +1,40 @@
+package nexus
+
+import (
+	"testing"
+	"time"
+)
+
+// --- matchSecret ---
+
+func TestMatchSecret(t *testing.T) {
+	now := time.Now()
+	keys := []SecretKey{
+		{Label: "a", Value: "alpha"},
+		{Label: "b", Value: "beta", ExpiresAt: now.Add(time.Hour)},
+		{Label: "c", Value: "gamma", ExpiresAt: now.Add(-time.Hour)},
+		{Label: "empty", Value: ""},
+	}
+
+	cases := map[string]string{"alpha": "a", "beta": "b"}
+	for secret, label := range cases {
+		key, ok := matchSecret(keys, secret, now)
+		if !ok || key.Label != label {
+			t.Fatalf("expected %s to match %s, got %v %s", secret, label, ok, key.Label)
+		}
+	}
+
+	for _, secret := range []string{"gamma", "", "alph"} {
+		if _, ok := matchSecret(keys, secret, now); ok {
+			t.Fatalf("expected %q to be rejected", secret)
+		}
+	}
+}
+
+func TestSecretKeys_IncludesServerSecret(t *testing.T) {
+	server := &Server{Secret: "legacy", SecretProvider: StaticSecrets{{Label: "next", Value: "rotated"}}}
+	keys := server.secretKeys()
+	if len(keys) != 2 || keys[0].Label != "default" || keys[1].Label != "next" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+}
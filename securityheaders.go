@@ -0,0 +1,266 @@
+package nexus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const cspNonceContextKey contextKey = "nexus.csp_nonce"
+
+// CSP source keywords
+const (
+	CSPSelf           = "'self'"
+	CSPNone           = "'none'"
+	CSPUnsafeInline   = "'unsafe-inline'"
+	CSPUnsafeEval     = "'unsafe-eval'"
+	CSPStrictDynamic  = "'strict-dynamic'"
+	CSPNonceSource    = "'nonce'" // CSPNonceSource is replaced by the nonce of every request ('nonce-...')
+	CSPUnsafeHashes   = "'unsafe-hashes'"
+	CSPWasmUnsafeEval = "'wasm-unsafe-eval'"
+)
+
+// SecurityHeaders contains the configuration of the SecurityHeadersMiddleware; the zero value sends safe defaults
+type SecurityHeaders struct {
+	HSTSMaxAge                time.Duration // HSTSMaxAge defaults to two years; HSTS is only sent over https
+	HSTSIncludeSubdomains     bool
+	HSTSPreload               bool
+	DisableHSTS               bool
+	FrameOptions              string // FrameOptions defaults to DENY
+	ReferrerPolicy            string // ReferrerPolicy defaults to strict-origin-when-cross-origin
+	PermissionsPolicy         string // PermissionsPolicy defaults to disabling camera, microphone and geolocation
+	CrossOriginOpenerPolicy   string // CrossOriginOpenerPolicy defaults to same-origin
+	CrossOriginEmbedderPolicy string // CrossOriginEmbedderPolicy is not sent unless set, e.g. require-corp
+	CrossOriginResourcePolicy string // CrossOriginResourcePolicy defaults to same-origin
+	ContentSecurityPolicy     *CSP   // ContentSecurityPolicy defaults to DefaultCSP
+	CSPReportOnly             bool   // CSPReportOnly sends the policy as Content-Security-Policy-Report-Only
+	DisableCSP                bool
+}
+
+// CSP build a Content-Security-Policy keeping the order of the directives
+type CSP struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// NewCSP create an empty policy
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+// DefaultCSP is a strict policy for APIs and server rendered pages without inline code
+func DefaultCSP() *CSP {
+	return NewCSP().
+		DefaultSrc(CSPSelf).
+		BaseURI(CSPSelf).
+		ObjectSrc(CSPNone).
+		FrameAncestors(CSPNone)
+}
+
+// Directive add sources to a directive; a directive without sources is sent as a flag (upgrade-insecure-requests)
+func (c *CSP) Directive(name string, sources ...string) *CSP {
+	for i := range c.directives {
+		if c.directives[i].name == name {
+			c.directives[i].sources = append(c.directives[i].sources, sources...)
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name: name, sources: sources})
+	return c
+}
+
+func (c *CSP) DefaultSrc(sources ...string) *CSP {
+	return c.Directive("default-src", sources...)
+}
+
+func (c *CSP) ScriptSrc(sources ...string) *CSP {
+	return c.Directive("script-src", sources...)
+}
+
+func (c *CSP) StyleSrc(sources ...string) *CSP {
+	return c.Directive("style-src", sources...)
+}
+
+func (c *CSP) ImgSrc(sources ...string) *CSP {
+	return c.Directive("img-src", sources...)
+}
+
+func (c *CSP) ConnectSrc(sources ...string) *CSP {
+	return c.Directive("connect-src", sources...)
+}
+
+func (c *CSP) FontSrc(sources ...string) *CSP {
+	return c.Directive("font-src", sources...)
+}
+
+func (c *CSP) ObjectSrc(sources ...string) *CSP {
+	return c.Directive("object-src", sources...)
+}
+
+func (c *CSP) MediaSrc(sources ...string) *CSP {
+	return c.Directive("media-src", sources...)
+}
+
+func (c *CSP) FrameSrc(sources ...string) *CSP {
+	return c.Directive("frame-src", sources...)
+}
+
+func (c *CSP) WorkerSrc(sources ...string) *CSP {
+	return c.Directive("worker-src", sources...)
+}
+
+func (c *CSP) ManifestSrc(sources ...string) *CSP {
+	return c.Directive("manifest-src", sources...)
+}
+
+func (c *CSP) FrameAncestors(sources ...string) *CSP {
+	return c.Directive("frame-ancestors", sources...)
+}
+
+func (c *CSP) BaseURI(sources ...string) *CSP {
+	return c.Directive("base-uri", sources...)
+}
+
+func (c *CSP) FormAction(sources ...string) *CSP {
+	return c.Directive("form-action", sources...)
+}
+
+func (c *CSP) ReportTo(group string) *CSP {
+	return c.Directive("report-to", group)
+}
+
+func (c *CSP) ReportURI(uri string) *CSP {
+	return c.Directive("report-uri", uri)
+}
+
+func (c *CSP) UpgradeInsecureRequests() *CSP {
+	return c.Directive("upgrade-insecure-requests")
+}
+
+// UsesNonce evaluate if the policy contains CSPNonceSource
+func (c *CSP) UsesNonce() bool {
+	for _, directive := range c.directives {
+		for _, source := range directive.sources {
+			if source == CSPNonceSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Build return the header value, replacing CSPNonceSource with the nonce
+func (c *CSP) Build(nonce string) string {
+	parts := make([]string, 0, len(c.directives))
+	for _, directive := range c.directives {
+		value := directive.name
+		for _, source := range directive.sources {
+			if source == CSPNonceSource {
+				source = fmt.Sprintf("'nonce-%s'", nonce)
+			}
+			value += " " + source
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CSPNonce return the nonce of the request, to be set in the nonce attribute of inline scripts and styles
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// headers resolve the defaults once, so every request only copies the values
+func (s SecurityHeaders) headers() (static map[string]string, hsts string) {
+	orDefault := func(value, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+
+	static = map[string]string{
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              orDefault(s.FrameOptions, "DENY"),
+		"Referrer-Policy":              orDefault(s.ReferrerPolicy, "strict-origin-when-cross-origin"),
+		"Permissions-Policy":           orDefault(s.PermissionsPolicy, "camera=(), microphone=(), geolocation=()"),
+		"Cross-Origin-Opener-Policy":   orDefault(s.CrossOriginOpenerPolicy, "same-origin"),
+		"Cross-Origin-Resource-Policy": orDefault(s.CrossOriginResourcePolicy, "same-origin"),
+	}
+	if s.CrossOriginEmbedderPolicy != "" {
+		static["Cross-Origin-Embedder-Policy"] = s.CrossOriginEmbedderPolicy
+	}
+
+	if !s.DisableHSTS {
+		maxAge := s.HSTSMaxAge
+		if maxAge == 0 {
+			maxAge = 2 * 365 * 24 * time.Hour
+		}
+		hsts = fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+		if s.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if s.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	return static, hsts
+}
+
+// SecurityHeadersMiddleware add the security headers of Server.SecurityHeaders to every response;
+// handlers can still override them. It is registered by ApplyMiddlewares when Server.SecurityHeaders is set.
+func (server *Server) SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	config := SecurityHeaders{}
+	if server.SecurityHeaders != nil {
+		config = *server.SecurityHeaders
+	}
+	static, hsts := config.headers()
+
+	csp := config.ContentSecurityPolicy
+	if csp == nil {
+		csp = DefaultCSP()
+	}
+	cspHeader := "Content-Security-Policy"
+	if config.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	usesNonce := csp.UsesNonce()
+	fixedPolicy := csp.Build("")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		for name, value := range static {
+			header.Set(name, value)
+		}
+		if hsts != "" && RequestScheme(r) == "https" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+
+		if !config.DisableCSP {
+			if usesNonce {
+				nonce := newCSPNonce()
+				header.Set(cspHeader, csp.Build(nonce))
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceContextKey, nonce))
+			} else {
+				header.Set(cspHeader, fixedPolicy)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,113 @@
+package nexus
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func securityHeadersRequest(config *SecurityHeaders, r *http.Request, inner http.HandlerFunc) *httptest.ResponseRecorder {
+	server := &Server{SecurityHeaders: config}
+	if inner == nil {
+		inner = func(w http.ResponseWriter, r *http.Request) {}
+	}
+	w := httptest.NewRecorder()
+	server.ApplyMiddlewares(inner).ServeHTTP(w, r)
+	return w
+}
+
+// --- SecurityHeadersMiddleware ---
+
+func TestSecurityHeaders_Defaults(t *testing.T) {
+	w := securityHeadersRequest(&SecurityHeaders{}, httptest.NewRequest("GET", "/", nil), nil)
+
+	expected := map[string]string{
+		"X-Content-Type-Options":     "nosniff",
+		"X-Frame-Options":            "DENY",
+		"Referrer-Policy":            "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy": "same-origin",
+		"Content-Security-Policy":    "default-src 'self'; base-uri 'self'; object-src 'none'; frame-ancestors 'none'",
+	}
+	for name, value := range expected {
+		if got := w.Header().Get(name); got != value {
+			t.Fatalf("expected %s %q, got %q", name, value, got)
+		}
+	}
+	if w.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatal("expected no HSTS over http")
+	}
+	if w.Header().Get("Cross-Origin-Embedder-Policy") != "" {
+		t.Fatal("expected no COEP by default")
+	}
+}
+
+func TestSecurityHeaders_HSTSOverHTTPS(t *testing.T) {
+	config := &SecurityHeaders{HSTSIncludeSubdomains: true, HSTSPreload: true}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	w := securityHeadersRequest(config, r, nil)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=63072000; includeSubDomains; preload" {
+		t.Fatalf("unexpected HSTS %q", hsts)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w = securityHeadersRequest(config, r, nil)
+	if w.Header().Get("Strict-Transport-Security") == "" {
+		t.Fatal("expected HSTS behind a TLS proxy")
+	}
+}
+
+func TestSecurityHeaders_NonceCSP(t *testing.T) {
+	config := &SecurityHeaders{
+		ContentSecurityPolicy: NewCSP().DefaultSrc(CSPSelf).ScriptSrc(CSPSelf, CSPNonceSource, CSPStrictDynamic),
+		CSPReportOnly:         true,
+	}
+
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		var nonce string
+		w := securityHeadersRequest(config, httptest.NewRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) {
+			nonce = CSPNonce(r)
+		})
+		policy := w.Header().Get("Content-Security-Policy-Report-Only")
+		if nonce == "" || !strings.Contains(policy, "script-src 'self' 'nonce-"+nonce+"' 'strict-dynamic'") {
+			t.Fatalf("expected policy with nonce %q, got %q", nonce, policy)
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Fatal("expected a different nonce per request")
+	}
+}
+
+func TestSecurityHeaders_Overrides(t *testing.T) {
+	config := &SecurityHeaders{FrameOptions: "SAMEORIGIN", DisableCSP: true, CrossOriginEmbedderPolicy: "require-corp"}
+	w := securityHeadersRequest(config, httptest.NewRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+	})
+
+	if w.Header().Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Fatal("expected configured X-Frame-Options")
+	}
+	if w.Header().Get("Content-Security-Policy") != "" {
+		t.Fatal("expected CSP to be disabled")
+	}
+	if w.Header().Get("Cross-Origin-Embedder-Policy") != "require-corp" {
+		t.Fatal("expected configured COEP")
+	}
+	if w.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Fatal("expected the handler to override the default")
+	}
+}
+
+func TestCSP_Build(t *testing.T) {
+	csp := NewCSP().DefaultSrc(CSPNone).ImgSrc(CSPSelf, "data:").ImgSrc("https://cdn.test").UpgradeInsecureRequests()
+	expected := "default-src 'none'; img-src 'self' data: https://cdn.test; upgrade-insecure-requests"
+	if policy := csp.Build(""); policy != expected {
+		t.Fatalf("expected %q, got %q", expected, policy)
+	}
+}
@@ -0,0 +1,514 @@
+package nexus
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const sessionContextKey contextKey = "nexus.session"
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionInvalid  = errors.New("session cookie is invalid")
+)
+
+// SessionStore persist the encoded sessions indexed by ID
+type SessionStore interface {
+	Load(id string) ([]byte, error) // Load return ErrSessionNotFound when the session does not exist or is expired
+	Save(id string, data []byte, expiresAt time.Time) error
+	Delete(id string) error
+}
+
+// SessionOptions contains the configuration of the Sessions middleware
+type SessionOptions struct {
+	Secret          []byte       // Secret signs the cookie; it is required
+	EncryptionKey   []byte       // EncryptionKey (16, 24 or 32 bytes) encrypts the cookie with AES-GCM
+	Store           SessionStore // Store keeps the sessions server side; when nil the whole session travels in the cookie
+	CookieName      string       // CookieName defaults to nexus_session
+	CookiePath      string       // CookiePath defaults to /
+	CookieDomain    string
+	Secure          bool          // Secure forces the Secure attribute; it is also set when RequestScheme is https
+	SameSite        http.SameSite // SameSite defaults to Lax
+	IdleTimeout     time.Duration // IdleTimeout expires sessions without activity (default 30m); the activity is saved every tenth of it
+	AbsoluteTimeout time.Duration // AbsoluteTimeout expires sessions since their creation (default 24h)
+	now             func() time.Time
+}
+
+// Session is the state kept between the requests of a client
+type Session struct {
+	ID        string                     `json:"id"`
+	Values    map[string]json.RawMessage `json:"values"`
+	Flash     []string                   `json:"flash,omitempty"`
+	CreatedAt time.Time                  `json:"created_at"`
+	LastSeen  time.Time                  `json:"last_seen"`
+
+	mu        sync.Mutex
+	isNew     bool
+	modified  bool
+	destroyed bool
+	previous  string // previous is the ID replaced by RenewID, deleted from the store on save
+}
+
+// GetSession return the session of the request; it is nil when the Sessions middleware is not active
+func GetSession(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionContextKey).(*Session)
+	return session
+}
+
+// SessionValue return the value of the key decoded into T
+func SessionValue[T any](session *Session, key string) (T, bool) {
+	var value T
+	session.mu.Lock()
+	raw, ok := session.Values[key]
+	session.mu.Unlock()
+	if !ok || json.Unmarshal(raw, &value) != nil {
+		return value, false
+	}
+	return value, true
+}
+
+// Set store a value in the session; the value must be JSON serializable
+func (s *Session) Set(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Values[key] = raw
+	s.modified = true
+	return nil
+}
+
+// Delete remove a value from the session
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Values, key)
+	s.modified = true
+}
+
+// AddFlash add a message that is kept until it is read with Flashes
+func (s *Session) AddFlash(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Flash = append(s.Flash, message)
+	s.modified = true
+}
+
+// Flashes return and clear the flash messages
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.Flash
+	if len(flashes) > 0 {
+		s.Flash = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// RenewID give the session a new ID keeping its values; call it on login and privilege changes
+// to prevent session fixation
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previous == "" && !s.isNew {
+		s.previous = s.ID
+	}
+	s.ID = newSessionID()
+	s.modified = true
+}
+
+// Destroy remove the session from the store and expire the cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.modified = true
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionManager load the session of the request and commit it before the response headers are written
+type sessionManager struct {
+	options SessionOptions
+	aead    cipher.AEAD
+}
+
+// Sessions return a middleware that load the session of the request into the context;
+// handlers read it with GetSession. It panics if the options are invalid.
+func Sessions(options SessionOptions) func(next http.Handler, server *Server) http.Handler {
+	if len(options.Secret) == 0 {
+		panic("nexus: SessionOptions.Secret is required")
+	}
+	if options.CookieName == "" {
+		options.CookieName = "nexus_session"
+	}
+	if options.CookiePath == "" {
+		options.CookiePath = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = 30 * time.Minute
+	}
+	if options.AbsoluteTimeout == 0 {
+		options.AbsoluteTimeout = 24 * time.Hour
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+
+	manager := &sessionManager{options: options}
+	if len(options.EncryptionKey) > 0 {
+		block, err := aes.NewCipher(options.EncryptionKey)
+		if err != nil {
+			panic("nexus: invalid SessionOptions.EncryptionKey: " + err.Error())
+		}
+		manager.aead, _ = cipher.NewGCM(block)
+	}
+
+	return func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := manager.load(r)
+			sw := &sessionWriter{ResponseWriter: w}
+			sw.commit = func() { manager.save(sw.ResponseWriter, r, session) }
+
+			ctx := context.WithValue(r.Context(), sessionContextKey, session)
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			sw.commitOnce()
+		})
+	}
+}
+
+// load return the session of the cookie or a new empty session
+func (m *sessionManager) load(r *http.Request) *Session {
+	now := m.options.now()
+	if cookie, err := r.Cookie(m.options.CookieName); err == nil {
+		if session, err := m.decode(cookie.Value); err == nil {
+			expired := now.Sub(session.LastSeen) > m.options.IdleTimeout ||
+				now.Sub(session.CreatedAt) > m.options.AbsoluteTimeout
+			if !expired {
+				return session
+			}
+			if m.options.Store != nil {
+				m.options.Store.Delete(session.ID)
+			}
+		}
+	}
+	return &Session{
+		ID:        newSessionID(),
+		Values:    make(map[string]json.RawMessage),
+		CreatedAt: now,
+		LastSeen:  now,
+		isNew:     true,
+	}
+}
+
+// save persist the session and write its cookie; untouched new sessions are not stored
+func (m *sessionManager) save(w http.ResponseWriter, r *http.Request, session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	store := m.options.Store
+	if session.previous != "" && store != nil {
+		store.Delete(session.previous)
+	}
+
+	if session.destroyed {
+		if store != nil && !session.isNew {
+			store.Delete(session.ID)
+		}
+		m.setCookie(w, r, "", -1)
+		return
+	}
+	if session.isNew && !session.modified {
+		return
+	}
+	now := m.options.now()
+	// Unchanged sessions are written again only after a tenth of the idle timeout, so every request
+	// does not rewrite the store and resend the cookie
+	if !session.modified && now.Sub(session.LastSeen) < m.options.IdleTimeout/10 {
+		return
+	}
+
+	session.LastSeen = now
+	expiresAt := now.Add(m.options.IdleTimeout)
+	if absolute := session.CreatedAt.Add(m.options.AbsoluteTimeout); absolute.Before(expiresAt) {
+		expiresAt = absolute
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+
+	value := data
+	if store != nil {
+		if store.Save(session.ID, data, expiresAt) != nil {
+			return
+		}
+		value = []byte(session.ID)
+	}
+	m.setCookie(w, r, m.encode(value), int(expiresAt.Sub(now).Seconds()))
+}
+
+func (m *sessionManager) setCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.options.CookieName,
+		Value:    value,
+		Path:     m.options.CookiePath,
+		Domain:   m.options.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   m.options.Secure || RequestScheme(r) == "https",
+		HttpOnly: true,
+		SameSite: m.options.SameSite,
+	})
+}
+
+// encode encrypt the value when an encryption key is configured and sign it
+func (m *sessionManager) encode(value []byte) string {
+	if m.aead != nil {
+		nonce := make([]byte, m.aead.NonceSize())
+		rand.Read(nonce)
+		value = m.aead.Seal(nonce, nonce, value, []byte(m.options.CookieName))
+	}
+	payload := base64.RawURLEncoding.EncodeToString(value)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(m.sign(payload))
+}
+
+// decode verify the signature of the cookie and return its session
+func (m *sessionManager) decode(cookie string) (*Session, error) {
+	i := len(cookie) - base64.RawURLEncoding.EncodedLen(sha256.Size) - 1
+	if i <= 0 || cookie[i] != '.' {
+		return nil, ErrSessionInvalid
+	}
+	payload := cookie[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(cookie[i+1:])
+	if err != nil || !hmac.Equal(signature, m.sign(payload)) {
+		return nil, ErrSessionInvalid
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+	if m.aead != nil {
+		size := m.aead.NonceSize()
+		if len(value) < size {
+			return nil, ErrSessionInvalid
+		}
+		value, err = m.aead.Open(nil, value[:size], value[size:], []byte(m.options.CookieName))
+		if err != nil {
+			return nil, ErrSessionInvalid
+		}
+	}
+
+	if m.options.Store != nil {
+		value, err = m.options.Store.Load(string(value))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	session := &Session{}
+	if err := json.Unmarshal(value, session); err != nil {
+		return nil, ErrSessionInvalid
+	}
+	if session.Values == nil {
+		session.Values = make(map[string]json.RawMessage)
+	}
+	return session, nil
+}
+
+func (m *sessionManager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, m.options.Secret)
+	mac.Write([]byte(m.options.CookieName + "=" + payload))
+	return mac.Sum(nil)
+}
+
+// sessionWriter commit the session right before the headers are sent
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) commitOnce() {
+	if !w.committed {
+		w.committed = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.commitOnce()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.commitOnce()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// MemorySessionStore keep the sessions in memory; they are lost when the server restarts
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemorySessionStore create an empty in-memory store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+func (s *MemorySessionStore) Load(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.expiresAt) {
+		delete(s.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return session.data, nil
+}
+
+func (s *MemorySessionStore) Save(id string, data []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[id] = memorySession{data: data, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// FileSessionStore keep every session in a file of a directory
+type FileSessionStore struct {
+	Dir string
+}
+
+type fileSession struct {
+	ExpiresAt time.Time       `json:"expires_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewFileSessionStore create the directory if needed and return a store that uses it
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{Dir: dir}, nil
+}
+
+// path return the file of the session; IDs are hex so they can never escape the directory
+func (s *FileSessionStore) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", ErrSessionNotFound
+	}
+	return filepath.Join(s.Dir, id+".session"), nil
+}
+
+func (s *FileSessionStore) Load(id string) ([]byte, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	var session fileSession
+	if json.Unmarshal(content, &session) != nil || time.Now().After(session.ExpiresAt) {
+		os.Remove(path)
+		return nil, ErrSessionNotFound
+	}
+	return session.Data, nil
+}
+
+func (s *FileSessionStore) Save(id string, data []byte, expiresAt time.Time) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(fileSession{ExpiresAt: expiresAt, Data: data})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file of its own and rename it, so readers never see a partial session
+	// and concurrent writers of a session do not mix their content
+	tmp, err := os.CreateTemp(s.Dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *FileSessionStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package nexus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionRoundTrip run the handler through the Sessions middleware, sending the given cookie
+func sessionRoundTrip(middleware func(next http.Handler, server *Server) http.Handler, cookie *http.Cookie, handler http.HandlerFunc) *http.Cookie {
+	server := &Server{EndpointsPaths: make(map[string]*Endpoint)}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	middleware(handler, server).ServeHTTP(w, r)
+
+	for _, c := range w.Result().Cookies() {
+		return c
+	}
+	return nil
+}
+
+// --- Sessions ---
+
+func TestSessions_CookieStore(t *testing.T) {
+	for _, key := range [][]byte{nil, []byte("0123456789abcdef")} {
+		middleware := Sessions(SessionOptions{Secret: []byte("sign"), EncryptionKey: key})
+
+		cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+			GetSession(r).Set("user", map[string]int{"id": 7})
+			w.WriteHeader(http.StatusOK)
+		})
+		if cookie == nil || !cookie.HttpOnly {
+			t.Fatalf("expected an HttpOnly session cookie, got %+v", cookie)
+		}
+
+		var id int
+		sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+			user, ok := SessionValue[map[string]int](GetSession(r), "user")
+			if ok {
+				id = user["id"]
+			}
+		})
+		if id != 7 {
+			t.Fatalf("expected user id 7 from the cookie, got %d", id)
+		}
+	}
+}
+
+func TestSessions_TamperedCookie(t *testing.T) {
+	middleware := Sessions(SessionOptions{Secret: []byte("sign")})
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Set("role", "user")
+	})
+
+	cookie.Value = "x" + cookie.Value[1:]
+	sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := SessionValue[string](GetSession(r), "role"); ok {
+			t.Fatal("expected tampered cookie to be ignored")
+		}
+	})
+}
+
+func TestSessions_NewUntouchedSessionIsNotSaved(t *testing.T) {
+	middleware := Sessions(SessionOptions{Secret: []byte("sign")})
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	if cookie != nil {
+		t.Fatalf("expected no cookie for an untouched session, got %+v", cookie)
+	}
+}
+
+func TestSessions_MemoryStoreRenewID(t *testing.T) {
+	store := NewMemorySessionStore()
+	middleware := Sessions(SessionOptions{Secret: []byte("sign"), Store: store})
+
+	var firstID string
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		session.Set("cart", 3)
+		firstID = session.ID
+	})
+
+	var secondID string
+	renewed := sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		session.RenewID()
+		session.Set("user", "ana")
+		secondID = session.ID
+	})
+
+	if firstID == secondID {
+		t.Fatal("expected RenewID to change the session ID")
+	}
+	if _, err := store.Load(firstID); err != ErrSessionNotFound {
+		t.Fatalf("expected the previous session to be deleted, got %v", err)
+	}
+
+	var cart int
+	sessionRoundTrip(middleware, renewed, func(w http.ResponseWriter, r *http.Request) {
+		cart, _ = SessionValue[int](GetSession(r), "cart")
+	})
+	if cart != 3 {
+		t.Fatalf("expected values to survive the renewal, got %d", cart)
+	}
+}
+
+func TestSessions_Expiry(t *testing.T) {
+	now := time.Now()
+	options := SessionOptions{
+		Secret:          []byte("sign"),
+		IdleTimeout:     time.Minute,
+		AbsoluteTimeout: time.Hour,
+		now:             func() time.Time { return now },
+	}
+	middleware := Sessions(options)
+
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Set("k", "v")
+	})
+
+	// Activity within the idle timeout slides the expiration
+	for i := 0; i < 3; i++ {
+		now = now.Add(50 * time.Second)
+		cookie = sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := SessionValue[string](GetSession(r), "k"); !ok {
+				t.Fatal("expected the session to be alive")
+			}
+		})
+	}
+
+	now = now.Add(2 * time.Minute)
+	sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := SessionValue[string](GetSession(r), "k"); ok {
+			t.Fatal("expected the idle session to be expired")
+		}
+	})
+}
+
+func TestSessions_FlashAndDestroy(t *testing.T) {
+	store, _ := NewFileSessionStore(t.TempDir())
+	middleware := Sessions(SessionOptions{Secret: []byte("sign"), Store: store})
+
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).AddFlash("saved")
+	})
+
+	var flashes []string
+	cookie = sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		flashes = GetSession(r).Flashes()
+	})
+	if len(flashes) != 1 || flashes[0] != "saved" {
+		t.Fatalf("expected the flash message, got %v", flashes)
+	}
+
+	sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		if len(GetSession(r).Flashes()) != 0 {
+			t.Fatal("expected flashes to be consumed")
+		}
+	})
+
+	expired := sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Destroy()
+	})
+	if expired == nil || expired.MaxAge >= 0 {
+		t.Fatalf("expected an expired cookie, got %+v", expired)
+	}
+}
+
+// --- FileSessionStore ---
+
+func TestFileSessionStore(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := store.Save("abcd", []byte(`{"id":"abcd"}`), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := store.Load("abcd")
+	if err != nil || string(data) != `{"id":"abcd"}` {
+		t.Fatalf("unexpected load %q %v", data, err)
+	}
+
+	store.Save("ef01", []byte(`{}`), time.Now().Add(-time.Minute))
+	if _, err := store.Load("ef01"); err != ErrSessionNotFound {
+		t.Fatalf("expected expired session to be missing, got %v", err)
+	}
+	if _, err := store.Load("../etc/passwd"); err != ErrSessionNotFound {
+		t.Fatalf("expected invalid ID to be rejected, got %v", err)
+	}
+
+	store.Delete("abcd")
+	if _, err := store.Load("abcd"); err != ErrSessionNotFound {
+		t.Fatalf("expected deleted session to be missing, got %v", err)
+	}
+
+	// Concurrent writers of a session never leave a mixed or temporary file
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Save("abcd", []byte(fmt.Sprintf(`{"writer":%d,"padding":%q}`, i, strings.Repeat("x", 4096))), time.Now().Add(time.Minute))
+		}(i)
+	}
+	wg.Wait()
+	data, err = store.Load("abcd")
+	if err != nil || !json.Valid(data) {
+		t.Fatalf("expected a complete session, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(store.Dir, "*.tmp")); len(matches) != 0 {
+		t.Fatalf("expected no temporary files, got %v", matches)
+	}
+}
+
+func TestSessions_UnchangedSessionIsNotRewritten(t *testing.T) {
+	now := time.Now()
+	store := NewMemorySessionStore()
+	middleware := Sessions(SessionOptions{Secret: []byte("sign"), Store: store, now: func() time.Time { return now }})
+
+	cookie := sessionRoundTrip(middleware, nil, func(w http.ResponseWriter, r *http.Request) {
+		GetSession(r).Set("k", "v")
+	})
+
+	now = now.Add(time.Second)
+	if again := sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {}); again != nil {
+		t.Fatalf("expected no cookie for an unchanged session, got %+v", again)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if again := sessionRoundTrip(middleware, cookie, func(w http.ResponseWriter, r *http.Request) {}); again == nil {
+		t.Fatal("expected the activity to be saved after a tenth of the idle timeout")
+	}
+}
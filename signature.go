@@ -0,0 +1,190 @@
+package nexus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureFormat is the way the signature is transported and computed
+type SignatureFormat int
+
+const (
+	// SignatureCanonical sign "METHOD\nPATH?QUERY\nTIMESTAMP\nhex(sha256(body))" and send the hex HMAC in the signature header
+	// and the unix timestamp in the timestamp header
+	SignatureCanonical SignatureFormat = iota
+	// SignatureGitHub sign the body and send "sha256=<hex>" in X-Hub-Signature-256; it carries no timestamp
+	SignatureGitHub
+	// SignatureStripe sign "TIMESTAMP.body" and send "t=<unix>,v1=<hex>" in Stripe-Signature
+	SignatureStripe
+)
+
+var (
+	ErrSignatureMissing = errors.New("signature is missing")
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	ErrSignatureExpired = errors.New("signature timestamp is outside the tolerance window")
+)
+
+// SignatureOptions contains the configuration of the VerifySignature middleware
+type SignatureOptions struct {
+	Format          SignatureFormat
+	Secrets         []string      // Secrets accepted; several can be active during a rotation
+	SignatureHeader string        // SignatureHeader overrides the header of the format
+	TimestampHeader string        // TimestampHeader is used by SignatureCanonical (default X-Timestamp)
+	Tolerance       time.Duration // Tolerance is the replay window around the timestamp (default 5m)
+	MaxBodyBytes    int64         // MaxBodyBytes limits the body read for verification (default 1MB)
+	now             func() time.Time
+}
+
+func (options *SignatureOptions) header() string {
+	if options.SignatureHeader != "" {
+		return options.SignatureHeader
+	}
+	switch options.Format {
+	case SignatureGitHub:
+		return "X-Hub-Signature-256"
+	case SignatureStripe:
+		return "Stripe-Signature"
+	}
+	return "X-Signature"
+}
+
+// SignRequest compute the canonical signature of a request, so clients written in Go can sign their calls
+func SignRequest(secret, method, path string, timestamp int64, body []byte) string {
+	return hex.EncodeToString(hmacSHA256([]byte(secret), canonicalSigningString(method, path, timestamp, body)))
+}
+
+func canonicalSigningString(method, path string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%s", strings.ToUpper(method), path, timestamp, hex.EncodeToString(bodyHash[:])))
+}
+
+func hmacSHA256(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// VerifySignature return a middleware that verify the HMAC-SHA256 signature of the request; it is meant
+// to be used in GroupOptions.Middlewares for webhooks and service-to-service endpoints.
+// After the verification the handler reads the original body as usual.
+func VerifySignature(options SignatureOptions) func(next http.Handler) http.Handler {
+	if options.Tolerance == 0 {
+		options.Tolerance = 5 * time.Minute
+	}
+	if options.MaxBodyBytes == 0 {
+		options.MaxBodyBytes = 1 << 20
+	}
+	if options.TimestampHeader == "" {
+		options.TimestampHeader = "X-Timestamp"
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, options.MaxBodyBytes))
+			if err != nil {
+				var maxBytesError *http.MaxBytesError
+				if errors.As(err, &maxBytesError) {
+					ResponseWithError(w, http.StatusRequestEntityTooLarge, "body too large")
+					return
+				}
+				ResponseWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			r.Body.Close()
+
+			if err := options.verify(r, body); err != nil {
+				ResponseWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// verify check the signature of the request against every secret
+func (options *SignatureOptions) verify(r *http.Request, body []byte) error {
+	value := r.Header.Get(options.header())
+	if value == "" {
+		return ErrSignatureMissing
+	}
+
+	var signatures []string
+	var signed func(secret string) []byte
+
+	switch options.Format {
+	case SignatureGitHub:
+		signatures = []string{strings.TrimPrefix(value, "sha256=")}
+		signed = func(secret string) []byte { return hmacSHA256([]byte(secret), body) }
+
+	case SignatureStripe:
+		var timestamp string
+		for _, part := range strings.Split(value, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = val
+			case "v1":
+				signatures = append(signatures, val)
+			}
+		}
+		if err := options.checkTimestamp(timestamp); err != nil {
+			return err
+		}
+		payload := append([]byte(timestamp+"."), body...)
+		signed = func(secret string) []byte { return hmacSHA256([]byte(secret), payload) }
+
+	default:
+		timestamp := r.Header.Get(options.TimestampHeader)
+		if err := options.checkTimestamp(timestamp); err != nil {
+			return err
+		}
+		ts, _ := strconv.ParseInt(timestamp, 10, 64)
+		payload := canonicalSigningString(r.Method, r.URL.RequestURI(), ts, body)
+		signatures = []string{value}
+		signed = func(secret string) []byte { return hmacSHA256([]byte(secret), payload) }
+	}
+
+	for _, secret := range options.Secrets {
+		expected := signed(secret)
+		for _, signature := range signatures {
+			given, err := hex.DecodeString(signature)
+			if err == nil && hmac.Equal(given, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// checkTimestamp reject requests whose timestamp is outside the replay window
+func (options *SignatureOptions) checkTimestamp(timestamp string) error {
+	if timestamp == "" {
+		return ErrSignatureMissing
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	diff := options.now().Sub(time.Unix(ts, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > options.Tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
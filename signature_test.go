@@ -0,0 +1,120 @@
+package nexus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signatureHandler(options SignatureOptions) (http.Handler, *string) {
+	var received string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusOK)
+	})
+	return VerifySignature(options)(inner), &received
+}
+
+// --- VerifySignature ---
+
+func TestVerifySignature_Canonical(t *testing.T) {
+	handler, received := signatureHandler(SignatureOptions{Secrets: []string{"old", "new"}})
+
+	body := `{"event":"paid"}`
+	ts := time.Now().Unix()
+	r := httptest.NewRequest("POST", "/hooks/pay?x=1", strings.NewReader(body))
+	r.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+	r.Header.Set("X-Signature", SignRequest("new", "POST", "/hooks/pay?x=1", ts, []byte(body)))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if *received != body {
+		t.Fatalf("expected handler to read the original body, got %q", *received)
+	}
+}
+
+func TestVerifySignature_CanonicalTamperedPath(t *testing.T) {
+	handler, _ := signatureHandler(SignatureOptions{Secrets: []string{"secret"}})
+
+	ts := time.Now().Unix()
+	r := httptest.NewRequest("POST", "/hooks/other", strings.NewReader("{}"))
+	r.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+	r.Header.Set("X-Signature", SignRequest("secret", "POST", "/hooks/pay", ts, []byte("{}")))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestVerifySignature_Replay(t *testing.T) {
+	handler, _ := signatureHandler(SignatureOptions{Secrets: []string{"secret"}, Tolerance: time.Minute})
+
+	ts := time.Now().Add(-2 * time.Minute).Unix()
+	r := httptest.NewRequest("POST", "/hooks", strings.NewReader("{}"))
+	r.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+	r.Header.Set("X-Signature", SignRequest("secret", "POST", "/hooks", ts, []byte("{}")))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrSignatureExpired.Error()) {
+		t.Fatalf("expected 401 for replayed request, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVerifySignature_GitHub(t *testing.T) {
+	handler, _ := signatureHandler(SignatureOptions{Format: SignatureGitHub, Secrets: []string{"gh"}})
+
+	body := `{"action":"opened"}`
+	r := httptest.NewRequest("POST", "/github", strings.NewReader(body))
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(hmacSHA256([]byte("gh"), []byte(body))))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestVerifySignature_Stripe(t *testing.T) {
+	handler, _ := signatureHandler(SignatureOptions{Format: SignatureStripe, Secrets: []string{"whsec"}})
+
+	body := `{"type":"charge.succeeded"}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hex.EncodeToString(hmacSHA256([]byte("whsec"), []byte(ts+"."+body)))
+	r := httptest.NewRequest("POST", "/stripe", strings.NewReader(body))
+	r.Header.Set("Stripe-Signature", fmt.Sprintf("t=%s,v1=deadbeef,v1=%s", ts, sig))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVerifySignature_MissingAndTooLarge(t *testing.T) {
+	handler, _ := signatureHandler(SignatureOptions{Secrets: []string{"secret"}, MaxBodyBytes: 4})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/hooks", strings.NewReader("{}")))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without signature, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/hooks", strings.NewReader("0123456789")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for large body, got %d", w.Code)
+	}
+}
@@ -0,0 +1,380 @@
+package nexus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed is returned by the writes on a closed SSEStream
+var ErrStreamClosed = errors.New("the event stream is closed")
+
+// SSEEvent is a message of a Server-Sent Events stream; Data that is not a string or []byte is sent as JSON
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  any
+	Retry time.Duration // Retry tells the client how long to wait before reconnecting
+}
+
+// SSEStream write events to a client; it is safe for concurrent use
+type SSEStream struct {
+	w          http.ResponseWriter
+	r          *http.Request
+	controller *http.ResponseController
+	mu         sync.Mutex
+	stop       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+}
+
+// SSE start a Server-Sent Events stream: it sends the headers, removes the write deadline of the server
+// so the stream can outlive Server.WriteTimeout, and flushes through the middlewares, including Compression.
+// The stream ends when the client disconnects, see Done.
+//
+//	stream, err := nexus.SSE(w, r)
+//	if err != nil {
+//		return
+//	}
+//	stream.Heartbeat(15 * time.Second)
+//	for progress := range job.Progress(stream.LastEventID()) {
+//		if err := stream.Send(nexus.SSEEvent{ID: progress.ID, Event: "progress", Data: progress}); err != nil {
+//			return
+//		}
+//	}
+func SSE(w http.ResponseWriter, r *http.Request) (*SSEStream, error) {
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, err
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Reverse proxies such as nginx must not buffer the stream
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &SSEStream{w: w, r: r, controller: controller, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		select {
+		case <-r.Context().Done():
+		case <-stream.stop:
+		}
+		close(stream.done)
+	}()
+	if err := controller.Flush(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// LastEventID return the ID of the last event received by a reconnecting client, to resume the stream
+func (s *SSEStream) LastEventID() string {
+	if id := s.r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return s.r.URL.Query().Get("lastEventId")
+}
+
+// Done is closed when the client disconnects or the stream is closed
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send write an event and flush it
+func (s *SSEStream) Send(event SSEEvent) error {
+	var b strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", sseField(event.ID))
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", sseField(event.Event))
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", event.Retry.Milliseconds())
+	}
+
+	var data string
+	switch value := event.Data.(type) {
+	case nil:
+	case string:
+		data = value
+	case []byte:
+		data = string(value)
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		data = string(encoded)
+	}
+	if event.Data != nil {
+		for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment write a comment line, which clients ignore
+func (s *SSEStream) Comment(text string) error {
+	return s.write(": " + sseField(text) + "\n\n")
+}
+
+// Retry tell the client how long to wait before reconnecting
+func (s *SSEStream) Retry(d time.Duration) error {
+	return s.write(fmt.Sprintf("retry: %d\n\n", d.Milliseconds()))
+}
+
+// Heartbeat send a comment every interval until the stream ends, so proxies do not close an idle connection;
+// the handler must Close the stream before returning
+func (s *SSEStream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if s.Comment("ping") != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close stop the heartbeat and make the following writes fail; the handler must return to end the response
+func (s *SSEStream) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *SSEStream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		return ErrStreamClosed
+	default:
+	}
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write([]byte(text)); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+// sseField remove the line breaks that would end a field
+func sseField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(value)
+}
+
+// SSEHubOptions contains the configuration of an SSEHub
+type SSEHubOptions struct {
+	History   int           // History is the number of events kept per topic to resume streams (default 100)
+	Buffer    int           // Buffer is the number of events queued per client; slow clients are dropped (default 32)
+	Heartbeat time.Duration // Heartbeat is the interval of the keepalive comments (default 15s)
+	// HistoryTTL is how long a topic without clients keeps its history after the last event (default 5m);
+	// then the topic is deleted
+	HistoryTTL time.Duration
+}
+
+// SSEHub broadcast the events published to a topic to every subscribed client
+type SSEHub struct {
+	options SSEHubOptions
+	mu      sync.Mutex
+	nextID  uint64
+	topics  map[string]*sseTopic
+
+	lastSweep time.Time // lastSweep is when the idle topics were last deleted
+}
+
+type sseTopic struct {
+	history     []SSEEvent
+	subscribers map[*sseSubscriber]struct{}
+	updated     time.Time // updated is the time of the last event
+}
+
+type sseSubscriber struct {
+	events  chan SSEEvent
+	dropped chan struct{}
+}
+
+// NewSSEHub create a hub
+func NewSSEHub(options SSEHubOptions) *SSEHub {
+	if options.History == 0 {
+		options.History = 100
+	}
+	if options.Buffer == 0 {
+		options.Buffer = 32
+	}
+	if options.Heartbeat == 0 {
+		options.Heartbeat = 15 * time.Second
+	}
+	if options.HistoryTTL == 0 {
+		options.HistoryTTL = 5 * time.Minute
+	}
+	return &SSEHub{options: options, topics: make(map[string]*sseTopic)}
+}
+
+// Publish send an event to the subscribers of the topic; events without ID get a sequential one so
+// clients can resume with Last-Event-ID
+func (h *SSEHub) Publish(topic string, event SSEEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.ID == "" {
+		h.nextID++
+		event.ID = strconv.FormatUint(h.nextID, 10)
+	}
+	now := time.Now()
+	h.sweep(now)
+	t := h.topic(topic)
+	t.updated = now
+	t.history = append(t.history, event)
+	if len(t.history) > h.options.History {
+		t.history = t.history[len(t.history)-h.options.History:]
+	}
+
+	for subscriber := range t.subscribers {
+		select {
+		case subscriber.events <- event:
+		default:
+			// The client cannot keep up; it reconnects and resumes from its last event
+			delete(t.subscribers, subscriber)
+			close(subscriber.dropped)
+		}
+	}
+}
+
+// Subscribers return the number of clients of a topic
+func (h *SSEHub) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.topics[topic]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// Subscribe stream the events of the topic to the client until it disconnects, first replaying the events
+// after its Last-Event-ID
+func (h *SSEHub) Subscribe(w http.ResponseWriter, r *http.Request, topic string) error {
+	stream, err := SSE(w, r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	subscriber := &sseSubscriber{events: make(chan SSEEvent, h.options.Buffer), dropped: make(chan struct{})}
+	h.mu.Lock()
+	t := h.topic(topic)
+	missed := eventsAfter(t.history, stream.LastEventID())
+	t.subscribers[subscriber] = struct{}{}
+	h.mu.Unlock()
+	defer h.unsubscribe(topic, subscriber)
+
+	for _, event := range missed {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	stream.Heartbeat(h.options.Heartbeat)
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-subscriber.dropped:
+			return nil
+		case event := <-subscriber.events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Handler return a handler that subscribe the clients to the topic
+func (h *SSEHub) Handler(topic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.Subscribe(w, r, topic)
+	}
+}
+
+func (h *SSEHub) topic(name string) *sseTopic {
+	t, ok := h.topics[name]
+	if !ok {
+		t = &sseTopic{subscribers: make(map[*sseSubscriber]struct{})}
+		h.topics[name] = t
+	}
+	return t
+}
+
+func (h *SSEHub) unsubscribe(topic string, subscriber *sseSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if t, ok := h.topics[topic]; ok {
+		delete(t.subscribers, subscriber)
+		if h.idle(t, now) {
+			delete(h.topics, topic)
+		}
+	}
+	h.sweep(now)
+}
+
+// Close end the streams of the topic and delete its history, e.g. when a job is finished
+func (h *SSEHub) Close(topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.topics[topic]; ok {
+		for subscriber := range t.subscribers {
+			close(subscriber.dropped)
+		}
+		delete(h.topics, topic)
+	}
+}
+
+// sweep delete the idle topics, at most once per HistoryTTL
+func (h *SSEHub) sweep(now time.Time) {
+	if now.Sub(h.lastSweep) < h.options.HistoryTTL {
+		return
+	}
+	h.lastSweep = now
+	for name, t := range h.topics {
+		if h.idle(t, now) {
+			delete(h.topics, name)
+		}
+	}
+}
+
+// idle evaluate if a topic has no clients and its history is too old to resume a stream
+func (h *SSEHub) idle(t *sseTopic, now time.Time) bool {
+	return len(t.subscribers) == 0 && now.Sub(t.updated) >= h.options.HistoryTTL
+}
+
+// eventsAfter return the events after the last received one; an unknown ID replays nothing
+func eventsAfter(history []SSEEvent, lastID string) []SSEEvent {
+	if lastID == "" {
+		return nil
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == lastID {
+			return append([]SSEEvent{}, history[i+1:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package nexus
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent read the lines of one event, up to the blank line that ends it
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v (got %q)", err, lines)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestSSE_FormatsEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r)
+		if err != nil {
+			t.Errorf("SSE: %v", err)
+			return
+		}
+		defer stream.Close()
+		stream.Send(SSEEvent{ID: "1", Event: "progress", Data: map[string]int{"percent": 50}, Retry: 3 * time.Second})
+		stream.Send(SSEEvent{Data: "line one\nline two"})
+		stream.Comment("ping")
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	first := strings.Join(readEvent(t, reader), "|")
+	if first != `id: 1|event: progress|retry: 3000|data: {"percent":50}` {
+		t.Errorf("first event = %q", first)
+	}
+	second := strings.Join(readEvent(t, reader), "|")
+	if second != "data: line one|data: line two" {
+		t.Errorf("second event = %q", second)
+	}
+	if comment := strings.Join(readEvent(t, reader), "|"); comment != ": ping" {
+		t.Errorf("comment = %q", comment)
+	}
+}
+
+func TestSSE_FlushesThroughCompression(t *testing.T) {
+	sent := make(chan struct{})
+	handler := compressionHandler(CompressionOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r)
+		if err != nil {
+			t.Errorf("SSE: %v", err)
+			return
+		}
+		defer stream.Close()
+		stream.Send(SSEEvent{Event: "ready", Data: "ok"})
+		// The event must reach the client while the handler is still running
+		<-sent
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	defer close(sent)
+
+	req, _ := http.NewRequest("GET", ts.URL+"/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", enc)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := strings.Join(readEvent(t, bufio.NewReader(gz)), "|")
+	if event != "event: ready|data: ok" {
+		t.Errorf("event = %q", event)
+	}
+}
+
+func TestSSE_ClearsWriteDeadline(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r)
+		if err != nil {
+			t.Errorf("SSE: %v", err)
+			return
+		}
+		defer stream.Close()
+		time.Sleep(150 * time.Millisecond)
+		stream.Send(SSEEvent{Data: "late"})
+	}))
+	ts.Config.WriteTimeout = 50 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if event := strings.Join(readEvent(t, bufio.NewReader(resp.Body)), "|"); event != "data: late" {
+		t.Errorf("event = %q", event)
+	}
+}
+
+func TestSSE_DisconnectEndsStream(t *testing.T) {
+	ended := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := SSE(w, r)
+		if err != nil {
+			ended <- err
+			return
+		}
+		defer stream.Close()
+		<-stream.Done()
+		ended <- stream.Send(SSEEvent{Data: "gone"})
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Error("Send after disconnect must fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream did not end after the client disconnected")
+	}
+}
+
+func TestSSE_LastEventID(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/events", nil)
+	r.Header.Set("Last-Event-ID", "42")
+	stream, err := SSE(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if id := stream.LastEventID(); id != "42" {
+		t.Errorf("LastEventID = %q, want 42", id)
+	}
+
+	stream.Close()
+	if err := stream.Send(SSEEvent{Data: "x"}); err != ErrStreamClosed {
+		t.Errorf("Send after Close = %v, want ErrStreamClosed", err)
+	}
+}
+
+func TestSSEHub_BroadcastAndResume(t *testing.T) {
+	hub := NewSSEHub(SSEHubOptions{History: 10})
+	ts := httptest.NewServer(hub.Handler("jobs"))
+	defer ts.Close()
+
+	hub.Publish("jobs", SSEEvent{Event: "progress", Data: "10"})
+	hub.Publish("jobs", SSEEvent{Event: "progress", Data: "20"})
+	hub.Publish("other", SSEEvent{Data: "ignored"})
+
+	subscribe := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resumed, resumedReader := subscribe("1")
+	defer resumed.Body.Close()
+	if event := strings.Join(readEvent(t, resumedReader), "|"); event != "id: 2|event: progress|data: 20" {
+		t.Errorf("replayed event = %q", event)
+	}
+
+	fresh, freshReader := subscribe("")
+	defer fresh.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Subscribers("jobs") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribers = %d, want 2", hub.Subscribers("jobs"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hub.Publish("jobs", SSEEvent{Event: "done", Data: "100"})
+	for _, reader := range []*bufio.Reader{resumedReader, freshReader} {
+		if event := strings.Join(readEvent(t, reader), "|"); event != "id: 4|event: done|data: 100" {
+			t.Errorf("broadcast event = %q", event)
+		}
+	}
+}
+
+func TestSSEHub_DropsSlowSubscribers(t *testing.T) {
+	hub := NewSSEHub(SSEHubOptions{Buffer: 1})
+	subscriber := &sseSubscriber{events: make(chan SSEEvent, 1), dropped: make(chan struct{})}
+	hub.topic("jobs").subscribers[subscriber] = struct{}{}
+
+	hub.Publish("jobs", SSEEvent{Data: "1"})
+	hub.Publish("jobs", SSEEvent{Data: "2"})
+
+	select {
+	case <-subscriber.dropped:
+	default:
+		t.Fatal("a subscriber with a full buffer must be dropped")
+	}
+	if n := hub.Subscribers("jobs"); n != 0 {
+		t.Errorf("subscribers = %d, want 0", n)
+	}
+}
+
+func TestSSEHub_DeletesIdleTopics(t *testing.T) {
+	hub := NewSSEHub(SSEHubOptions{HistoryTTL: 20 * time.Millisecond})
+	subscriber := &sseSubscriber{events: make(chan SSEEvent, 1), dropped: make(chan struct{})}
+	hub.topic("job-1").subscribers[subscriber] = struct{}{}
+	hub.Publish("job-1", SSEEvent{Data: "done"})
+	hub.Publish("job-2", SSEEvent{Data: "done"})
+
+	// The history is kept for the reconnections, then the topics are deleted
+	hub.unsubscribe("job-1", subscriber)
+	if len(hub.topics) != 2 {
+		t.Fatalf("topics = %d, want 2 while the history is recent", len(hub.topics))
+	}
+	time.Sleep(30 * time.Millisecond)
+	hub.Publish("job-3", SSEEvent{Data: "started"})
+	if _, ok := hub.topics["job-1"]; ok || len(hub.topics) != 1 {
+		t.Errorf("topics = %v, want only job-3", hub.topics)
+	}
+
+	// Close ends the streams of the topic
+	subscriber = &sseSubscriber{events: make(chan SSEEvent, 1), dropped: make(chan struct{})}
+	hub.topic("job-3").subscribers[subscriber] = struct{}{}
+	hub.Close("job-3")
+	select {
+	case <-subscriber.dropped:
+	default:
+		t.Fatal("Close must end the streams of the topic")
+	}
+	if len(hub.topics) != 0 {
+		t.Errorf("topics = %d, want 0", len(hub.topics))
+	}
+}
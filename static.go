@@ -0,0 +1,248 @@
+package nexus
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// StaticOptions contains the configuration of a static mount
+type StaticOptions struct {
+	Index           string   // Index is served for the directories (default index.html)
+	Browse          bool     // Browse lists the directories without index
+	SPA             bool     // SPA serves the root Index for the unknown paths of HTML navigations, so the client router can handle them
+	SPAExclude      []string // SPAExclude are path prefixes that answer 404 instead of the SPA Index, e.g. "/api/"
+	CacheControl    string   // CacheControl is sent with the files, e.g. "public, max-age=31536000, immutable"
+	NoPrecompressed bool     // NoPrecompressed ignores the .br and .gz variants of the files
+}
+
+// StaticHandler serve the files of an fs.FS
+type StaticHandler struct {
+	fsys    fs.FS
+	options StaticOptions
+	etags   sync.Map
+}
+
+// wildcardRegex find the name of the {name...} wildcard of an endpoint path
+var wildcardRegex = regexp.MustCompile(`\{(\w+)\.\.\.\}`)
+
+// Static create the handler of a static mount; the file is the {name...} wildcard of the endpoint path, so the
+// mount follows Settings.PathPrefix and the group paths like any endpoint:
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	admin, _ := fs.Sub(dist, "dist")
+//	{Path: "GET /admin/{file...}", Handler: nexus.Static(admin, nexus.StaticOptions{SPA: true}), Options: nexus.EndpointOptions{IsPublic: true}}
+//
+// The files are served with http.ServeContent, which answers Range and conditional requests. The ETag is
+// derived from the size and the modification time, or from the content when the FS has no times, as embed.FS.
+// The .br and .gz files next to a file are sent to the clients that accept them. Hidden files are never served.
+func Static(fsys fs.FS, options StaticOptions) *StaticHandler {
+	if options.Index == "" {
+		options.Index = "index.html"
+	}
+	return &StaticHandler{fsys: fsys, options: options}
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		ResponseWithError(w, http.StatusMethodNotAllowed, "only GET and HEAD are allowed")
+		return
+	}
+
+	requested := "/"
+	if match := wildcardRegex.FindStringSubmatch(r.Pattern); match != nil {
+		requested = "/" + r.PathValue(match[1])
+	}
+	name := strings.TrimPrefix(path.Clean(requested), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || hiddenPath(name) {
+		ResponseWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	info, err := fs.Stat(h.fsys, name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// Relative links of the index need the trailing slash
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		index := path.Join(name, h.options.Index)
+		if indexInfo, err := fs.Stat(h.fsys, index); err == nil && !indexInfo.IsDir() {
+			h.serveFile(w, r, index)
+			return
+		}
+		if h.options.Browse {
+			h.listDirectory(w, r, name)
+			return
+		}
+		err = fs.ErrNotExist
+	}
+
+	if err != nil {
+		if h.spaFallback(r, name) {
+			h.serveFile(w, r, h.options.Index)
+			return
+		}
+		ResponseWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	h.serveFile(w, r, name)
+}
+
+// spaFallback evaluate if a missing file is a route of the client application: only HTML navigations
+// to paths without extension fall back to the index
+func (h *StaticHandler) spaFallback(r *http.Request, name string) bool {
+	if !h.options.SPA || path.Ext(name) != "" {
+		return false
+	}
+	for _, prefix := range h.options.SPAExclude {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+	}
+	q, _ := acceptQuality(r.Header.Get("Accept"), "text/html")
+	return q > 0
+}
+
+// serveFile send a file, or its precompressed variant when the client accepts it
+func (h *StaticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	header := w.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	served := name
+
+	if !h.options.NoPrecompressed {
+		header.Add("Vary", "Accept-Encoding")
+		variants := map[string]string{}
+		var available []string
+		for _, variant := range []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+			if info, err := fs.Stat(h.fsys, name+variant.ext); err == nil && !info.IsDir() {
+				variants[variant.encoding] = name + variant.ext
+				available = append(available, variant.encoding)
+			}
+		}
+		if encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"), available); encoding != "" {
+			served = variants[encoding]
+			header.Set("Content-Encoding", encoding)
+		}
+	}
+
+	file, err := h.fsys.Open(served)
+	if err != nil {
+		ResponseWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		ResponseFromError(w, err)
+		return
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			ResponseFromError(w, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if contentType == "" && served != name {
+		// ServeContent would sniff the compressed bytes
+		contentType = "application/octet-stream"
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if h.options.CacheControl != "" {
+		header.Set("Cache-Control", h.options.CacheControl)
+	}
+	etag, err := h.etag(served, info, content)
+	if err != nil {
+		ResponseFromError(w, err)
+		return
+	}
+	header.Set("ETag", etag)
+
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag return the validator of a file; the files without time are hashed once, since such FS are immutable
+func (h *StaticHandler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	if etag, ok := h.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := ETag(data, false)
+	h.etags.Store(name, etag)
+	return etag, nil
+}
+
+func (h *StaticHandler) listDirectory(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(h.fsys, name)
+	if err != nil {
+		ResponseFromError(w, err)
+		return
+	}
+
+	var body strings.Builder
+	title := html.EscapeString(r.URL.Path)
+	fmt.Fprintf(&body, "<!doctype html>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<h1>%s</h1>\n<ul>\n", title, title)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&body, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	body.WriteString("</ul>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, body.String())
+	}
+}
+
+// hiddenPath evaluate if a segment of the path starts with a dot, as .env or .git/config
+func hiddenPath(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return true
+		}
+	}
+	return false
+}
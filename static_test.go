@@ -0,0 +1,171 @@
+package nexus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var adminFS = fstest.MapFS{
+	"index.html":          {Data: []byte("<!doctype html><div id=app></div>")},
+	"assets/app.css":      {Data: []byte("body{margin:0}"), ModTime: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"assets/app.js":       {Data: []byte("console.log('admin')")},
+	"assets/app.js.br":    {Data: []byte("brotli bytes")},
+	"assets/app.js.gz":    {Data: []byte("gzip bytes")},
+	"docs/guide.txt":      {Data: []byte("guide")},
+	"docs/<script>.txt":   {Data: []byte("escaped")},
+	".env":                {Data: []byte("SECRET=1")},
+	"docs/.hidden/a.txt":  {Data: []byte("hidden")},
+	"reports/2026/q1.csv": {Data: []byte("a,b")},
+}
+
+func staticServer(options StaticOptions) (*Server, http.Handler) {
+	server := &Server{ServerName: "Static", Settings: &Settings{PathPrefix: "/api/v1"}}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /users/{id}", HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+			ResponseWithJSON(w, http.StatusOK, map[string]string{"id": r.PathValue("id")})
+		}},
+		{Path: "GET /admin/{file...}", Handler: Static(adminFS, options), Options: EndpointOptions{IsPublic: true}},
+	})
+	return server, server.Handler()
+}
+
+func staticGet(handler http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestStatic_ServesFiles(t *testing.T) {
+	_, handler := staticServer(StaticOptions{CacheControl: "public, max-age=60"})
+
+	w := staticGet(handler, "/api/v1/admin/assets/app.css", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "body{margin:0}" {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if w.Header().Get("Last-Modified") == "" || w.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("headers = %v", w.Header())
+	}
+
+	etag := w.Header().Get("ETag")
+	if w := staticGet(handler, "/api/v1/admin/assets/app.css", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d, want 304", w.Code)
+	}
+
+	w = staticGet(handler, "/api/v1/admin/assets/app.css", map[string]string{"Range": "bytes=0-3"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "body" {
+		t.Errorf("Range: status = %d, body = %q", w.Code, w.Body.String())
+	}
+
+	// embed.FS and fstest.MapFS have no times, so the ETag comes from the content
+	w = staticGet(handler, "/api/v1/admin/index.html", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != ETag(adminFS["index.html"].Data, false) {
+		t.Errorf("index: status = %d, ETag = %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestStatic_Precompressed(t *testing.T) {
+	_, handler := staticServer(StaticOptions{})
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{"gzip, br", "br", "brotli bytes"},
+		{"gzip", "gzip", "gzip bytes"},
+		{"br;q=0, gzip", "gzip", "gzip bytes"},
+		{"", "", "console.log('admin')"},
+	}
+	for _, tt := range tests {
+		w := staticGet(handler, "/api/v1/admin/assets/app.js", map[string]string{"Accept-Encoding": tt.acceptEncoding})
+		if w.Header().Get("Content-Encoding") != tt.encoding || w.Body.String() != tt.body {
+			t.Errorf("%q: encoding = %q, body = %q", tt.acceptEncoding, w.Header().Get("Content-Encoding"), w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+			t.Errorf("%q: Content-Type = %q", tt.acceptEncoding, ct)
+		}
+	}
+}
+
+func TestStatic_Directories(t *testing.T) {
+	_, handler := staticServer(StaticOptions{})
+
+	if w := staticGet(handler, "/api/v1/admin/docs", nil); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/api/v1/admin/docs/" {
+		t.Errorf("redirect: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := staticGet(handler, "/api/v1/admin/", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "id=app") {
+		t.Errorf("index: status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w := staticGet(handler, "/api/v1/admin/docs/", nil); w.Code != http.StatusNotFound {
+		t.Errorf("listing must be off by default, status = %d", w.Code)
+	}
+
+	_, handler = staticServer(StaticOptions{Browse: true})
+	w := staticGet(handler, "/api/v1/admin/docs/", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="guide.txt"`) {
+		t.Errorf("listing: status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "<script>") || strings.Contains(w.Body.String(), ".hidden") {
+		t.Errorf("listing must escape the names and skip hidden files: %q", w.Body.String())
+	}
+}
+
+func TestStatic_SPAFallback(t *testing.T) {
+	_, handler := staticServer(StaticOptions{SPA: true, SPAExclude: []string{"/api/v1/admin/api/"}})
+	html := map[string]string{"Accept": "text/html,application/xhtml+xml"}
+
+	tests := []struct {
+		path   string
+		header map[string]string
+		status int
+	}{
+		{"/api/v1/admin/users/7", html, http.StatusOK},
+		{"/api/v1/admin/users/7", map[string]string{"Accept": "application/json"}, http.StatusNotFound},
+		{"/api/v1/admin/assets/missing.js", html, http.StatusNotFound},
+		{"/api/v1/admin/api/missing", html, http.StatusNotFound},
+		{"/api/v1/admin/.env", html, http.StatusNotFound},
+		{"/api/v1/admin/docs/.hidden/a.txt", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := staticGet(handler, tt.path, tt.header)
+		if w.Code != tt.status {
+			t.Errorf("%s %v: status = %d, want %d", tt.path, tt.header, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), "id=app") {
+			t.Errorf("%s: the index must be served, got %q", tt.path, w.Body.String())
+		}
+	}
+}
+
+func TestStatic_CatchAllDoesNotShadowEndpoints(t *testing.T) {
+	server := &Server{ServerName: "Static"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /users/{id}", HandlerFunc: func(w http.ResponseWriter, r *http.Request) {}, Options: EndpointOptions{RequiredRoles: []string{"admin"}}},
+		{Path: "GET /{file...}", Handler: Static(adminFS, StaticOptions{SPA: true}), Options: EndpointOptions{IsPublic: true}},
+	})
+	handler := server.Handler()
+
+	endpoint, ok := server.GetEndpoint(httptest.NewRequest("GET", "/users/7", nil))
+	if !ok || endpoint.Path != "GET /users/{id}" {
+		t.Errorf("GetEndpoint(/users/7) = %v", endpoint)
+	}
+	endpoint, ok = server.GetEndpoint(httptest.NewRequest("GET", "/settings/profile", nil))
+	if !ok || endpoint.Path != "GET /{file...}" {
+		t.Errorf("GetEndpoint(/settings/profile) = %v", endpoint)
+	}
+
+	if w := staticGet(handler, "/settings/profile", map[string]string{"Accept": "text/html"}); w.Code != http.StatusOK {
+		t.Errorf("SPA route: status = %d", w.Code)
+	}
+}
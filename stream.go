@@ -0,0 +1,276 @@
+package nexus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StreamErrorTrailer is the trailer that carries the code_name of an error that interrupted a stream
+const StreamErrorTrailer = "X-Stream-Error"
+
+// StreamOptions contains the configuration of StreamNDJSON and StreamJSONArray
+type StreamOptions struct {
+	FlushEvery    int           // FlushEvery flushes after that many items (default 100)
+	FlushInterval time.Duration // FlushInterval flushes the written items at most that time after the last flush, also while the producer waits (default 1s)
+	WriteTimeout  time.Duration // WriteTimeout limits each write, replacing the server timeout of the whole response (default 15s)
+}
+
+// StreamValues adapt an iterator without errors to the streaming functions
+func StreamValues[T any](seq iter.Seq[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for item := range seq {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// StreamChannel adapt a channel to the streaming functions; the stream ends when the channel is closed, or
+// with the error of ctx when it is done, so a disconnected client does not wait for a producer that never
+// closes the channel. Use the request context:
+//
+//	return nexus.StreamNDJSON(w, r, nexus.StreamChannel(r.Context(), events), nexus.StreamOptions{})
+func StreamChannel[T any](ctx context.Context, ch <-chan T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				var zero T
+				yield(zero, ctx.Err())
+				return
+			case item, ok := <-ch:
+				if !ok {
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// StreamNDJSON write every item as a line of newline-delimited JSON (application/x-ndjson) as soon as it is produced.
+// An error of the iterator before the first item is returned, so the handler can answer it as usual; later
+// errors end the stream with a {"error": ...} line and the X-Stream-Error trailer. The stream stops when the
+// client disconnects.
+//
+//	rows := func(yield func(Order, error) bool) {
+//		for rows.Next() {
+//			var order Order
+//			if err := rows.Scan(&order.ID, &order.Total); err != nil {
+//				yield(order, err)
+//				return
+//			}
+//			if !yield(order, nil) {
+//				return
+//			}
+//		}
+//	}
+//	return nexus.StreamNDJSON(w, r, rows, nexus.StreamOptions{})
+func StreamNDJSON[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error], options StreamOptions) error {
+	return streamJSON(w, r, seq, options, true)
+}
+
+// StreamJSONArray write the items as a JSON array as they are produced. The errors are handled like in
+// StreamNDJSON, except that the array is closed so the body is always valid JSON; clients must check the
+// X-Stream-Error trailer to know if the array is complete.
+func StreamJSONArray[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error], options StreamOptions) error {
+	return streamJSON(w, r, seq, options, false)
+}
+
+func streamJSON[T any](w http.ResponseWriter, r *http.Request, seq iter.Seq2[T, error], options StreamOptions, ndjson bool) error {
+	if options.FlushEvery == 0 {
+		options.FlushEvery = 100
+	}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = 15 * time.Second
+	}
+	s := &jsonStream{
+		w:          w,
+		controller: http.NewResponseController(w),
+		options:    options,
+		ndjson:     ndjson,
+		lastFlush:  time.Now(),
+	}
+	defer s.close()
+
+	for item, err := range seq {
+		if r.Context().Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return s.fail(err)
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return s.fail(err)
+		}
+		if !s.writeItem(data) {
+			// The client is gone
+			return nil
+		}
+	}
+
+	s.end()
+	return nil
+}
+
+type jsonStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	options    StreamOptions
+	ndjson     bool
+	started    bool
+	count      int
+	lastFlush  time.Time
+	failed     bool
+
+	// mu serializes the writes of the handler and the flushes of the timer
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool // pending tells that written items have not been flushed
+	closed  bool // closed tells that the handler has returned, so the timer must not use the writer
+}
+
+func (s *jsonStream) start() bool {
+	s.started = true
+	header := s.w.Header()
+	if s.ndjson {
+		header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		header.Set("Content-Type", "application/json")
+	}
+	header.Del("Content-Length")
+	header.Set("Trailer", StreamErrorTrailer)
+	s.w.WriteHeader(http.StatusOK)
+	if !s.ndjson {
+		return s.write([]byte("["))
+	}
+	return true
+}
+
+func (s *jsonStream) writeItem(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		if !s.start() {
+			return false
+		}
+	} else if !s.ndjson {
+		data = append([]byte(","), data...)
+	}
+	if s.ndjson {
+		data = append(data, '\n')
+	}
+	if !s.write(data) {
+		return false
+	}
+
+	s.count++
+	if s.count%s.options.FlushEvery == 0 || time.Since(s.lastFlush) >= s.options.FlushInterval {
+		return s.flush()
+	}
+	if !s.pending {
+		// The producer may wait before the next item, so the timer flushes the written ones
+		s.pending = true
+		delay := s.options.FlushInterval - time.Since(s.lastFlush)
+		if s.timer == nil {
+			s.timer = time.AfterFunc(delay, s.flushPending)
+		} else {
+			s.timer.Reset(delay)
+		}
+	}
+	return true
+}
+
+// flushPending flush the items written since the last flush, unless the stream is over
+func (s *jsonStream) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending && !s.closed {
+		s.flush()
+	}
+}
+
+// end close the array and flush the last items
+func (s *jsonStream) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		s.start()
+	}
+	if !s.ndjson {
+		s.write([]byte("]"))
+	}
+	s.flush()
+}
+
+// close stop the timer before the handler returns
+func (s *jsonStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// write send the data with a deadline of its own, so slow producers are not cut by the server timeout
+func (s *jsonStream) write(data []byte) bool {
+	if s.failed {
+		return false
+	}
+	if err := s.controller.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.failed = true
+		return false
+	}
+	if _, err := s.w.Write(data); err != nil {
+		s.failed = true
+		return false
+	}
+	return true
+}
+
+func (s *jsonStream) flush() bool {
+	s.lastFlush = time.Now()
+	s.pending = false
+	if err := s.controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.failed = true
+		return false
+	}
+	return !s.failed
+}
+
+// fail return the error when nothing has been sent, or end the stream without breaking its format
+func (s *jsonStream) fail(err error) error {
+	if !s.started {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolved := DefaultErrors.Resolve(err)
+	if resolved.Status >= http.StatusInternalServerError && resolved.Err != nil {
+		log.Printf("nexus: stream interrupted: %d %s: %v", resolved.Status, resolved.CodeName, resolved.Err)
+	}
+	if s.ndjson {
+		line, _ := json.Marshal(map[string]*ErrorResponse{"error": resolved.ErrorResponse()})
+		s.write(append(line, '\n'))
+	} else {
+		s.write([]byte("]"))
+	}
+	s.w.Header().Set(StreamErrorTrailer, resolved.CodeName)
+	s.flush()
+	return nil
+}
@@ -0,0 +1,235 @@
+package nexus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exportRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func exportRows(n int, failAt int) iter.Seq2[exportRow, error] {
+	return func(yield func(exportRow, error) bool) {
+		for i := 1; i <= n; i++ {
+			if i == failAt {
+				yield(exportRow{}, errors.New("database connection lost"))
+				return
+			}
+			if !yield(exportRow{ID: i, Name: "row"}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := StreamNDJSON(w, httptest.NewRequest("GET", "/export", nil), exportRows(3, 0), StreamOptions{FlushEvery: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "{\"id\":1,\"name\":\"row\"}\n{\"id\":2,\"name\":\"row\"}\n{\"id\":3,\"name\":\"row\"}\n"
+	if w.Body.String() != want {
+		t.Errorf("body = %q", w.Body.String())
+	}
+	if !w.Flushed {
+		t.Error("the stream must be flushed")
+	}
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	for _, n := range []int{0, 1, 250} {
+		w := httptest.NewRecorder()
+		if err := StreamJSONArray(w, httptest.NewRequest("GET", "/export", nil), exportRows(n, 0), StreamOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		var rows []exportRow
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Fatalf("%d rows: invalid JSON %q: %v", n, w.Body.String(), err)
+		}
+		if len(rows) != n {
+			t.Errorf("rows = %d, want %d", len(rows), n)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	StreamJSONArray(w, httptest.NewRequest("GET", "/export", nil), StreamValues(slices.Values([]string{"a", "b"})), StreamOptions{})
+	if w.Body.String() != `["a","b"]` {
+		t.Errorf("body = %q", w.Body.String())
+	}
+}
+
+func TestStream_ErrorBeforeFirstItem(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := StreamNDJSON(w, httptest.NewRequest("GET", "/export", nil), exportRows(3, 1), StreamOptions{})
+	if err == nil {
+		t.Fatal("the error must be returned while nothing has been sent")
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("nothing must be written, got %q", w.Body.String())
+	}
+}
+
+func TestStream_ErrorMidStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := StreamNDJSON(w, httptest.NewRequest("GET", "/export", nil), exportRows(5, 3), StreamOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var last struct {
+		Error ErrorResponse `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &last); err != nil || last.Error.CodeName != "internal_server_error" {
+		t.Errorf("error line = %q", lines[2])
+	}
+	if strings.Contains(lines[2], "database") {
+		t.Error("the cause of a server error must not be sent")
+	}
+	if trailer := w.Result().Trailer.Get(StreamErrorTrailer); trailer != "internal_server_error" {
+		t.Errorf("trailer = %q", trailer)
+	}
+
+	w = httptest.NewRecorder()
+	StreamJSONArray(w, httptest.NewRequest("GET", "/export", nil), exportRows(5, 3), StreamOptions{})
+	var rows []exportRow
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil || len(rows) != 2 {
+		t.Errorf("interrupted array = %q (%v)", w.Body.String(), err)
+	}
+	if trailer := w.Result().Trailer.Get(StreamErrorTrailer); trailer != "internal_server_error" {
+		t.Errorf("trailer = %q", trailer)
+	}
+}
+
+func TestStream_StopsOnDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var produced []int
+	seq := func(yield func(int, error) bool) {
+		for i := 1; i <= 100; i++ {
+			produced = append(produced, i)
+			if i == 2 {
+				cancel()
+			}
+			if !yield(i, nil) {
+				return
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/export", nil).WithContext(ctx)
+	if err := StreamNDJSON(w, r, seq, StreamOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(produced) != 2 {
+		t.Errorf("produced = %v, the iterator must stop after the disconnect", produced)
+	}
+}
+
+func TestStream_FlushesIncrementally(t *testing.T) {
+	rows := make(chan exportRow)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamNDJSON(w, r, StreamChannel(r.Context(), rows), StreamOptions{FlushEvery: 1})
+	}))
+	defer ts.Close()
+
+	go func() { rows <- exportRow{ID: 1, Name: "first"} }()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The first row arrives while the producer is still running
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "{\"id\":1,\"name\":\"first\"}\n" {
+		t.Fatalf("first line = %q (%v)", line, err)
+	}
+	close(rows)
+
+	rest, _ := reader.ReadString('\n')
+	if rest != "" {
+		t.Errorf("unexpected data after the end: %q", rest)
+	}
+	if resp.Trailer.Get(StreamErrorTrailer) != "" {
+		t.Errorf("a complete stream has no error, got %q", resp.Trailer.Get(StreamErrorTrailer))
+	}
+}
+
+func TestStream_FlushesWhileTheProducerWaits(t *testing.T) {
+	rows := make(chan exportRow)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamNDJSON(w, r, StreamChannel(r.Context(), rows), StreamOptions{FlushInterval: 20 * time.Millisecond})
+	}))
+	defer ts.Close()
+
+	go func() { rows <- exportRow{ID: 1, Name: "first"} }()
+
+	// The second row is produced only after the first one is received
+	var reader *bufio.Reader
+	lines := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			lines <- err.Error()
+			return
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		reader = bufio.NewReader(resp.Body)
+		line, _ := reader.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "{\"id\":1,\"name\":\"first\"}\n" {
+			t.Fatalf("first line = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		close(rows)
+		t.Fatal("the first row must be flushed while the producer waits")
+	}
+	rows <- exportRow{ID: 2, Name: "second"}
+	close(rows)
+	if line, _ := reader.ReadString('\n'); line != "{\"id\":2,\"name\":\"second\"}\n" {
+		t.Errorf("second line = %q", line)
+	}
+}
+
+func TestStreamChannel_StopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rows := make(chan exportRow)
+	done := make(chan error)
+	go func() {
+		w := httptest.NewRecorder()
+		done <- StreamNDJSON(w, httptest.NewRequest("GET", "/export", nil).WithContext(ctx), StreamChannel(ctx, rows), StreamOptions{})
+	}()
+
+	rows <- exportRow{ID: 1}
+	// The producer never closes the channel
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("a disconnect is not an error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream must stop when the context is done")
+	}
+}
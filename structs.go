@@ -3,6 +3,7 @@ package nexus
 import (
 	"net/http"
 	"regexp"
+	"sync"
 
 	"github.com/rs/cors"
 )
@@ -13,28 +14,44 @@ type Server struct {
 	ServerNumber         string
 	RunningServerMessage string
 	Secret               string
+	SecretProvider       SecretProvider // SecretProvider supplies additional rotating secrets accepted next to Secret
 	Debug                bool
 	Port                 string
 	Middlewares          []func(next http.Handler, server *Server) http.Handler
 	Endpoints            [][]Endpoint
 	EndpointsPaths       map[string]*Endpoint
 	CorsOptions          cors.Options
+	SecurityHeaders      *SecurityHeaders // SecurityHeaders, when set, adds HSTS, CSP and the other security headers to every response
 	Settings             *Settings
+
+	handlerOnce sync.Once
+	handler     http.Handler
 }
 
 type Settings struct {
-	IgnoreSecret bool
-	PathPrefix   string
+	IgnoreSecret       bool
+	PathPrefix         string
+	SecretHeader       string // SecretHeader is the header that carries the secret (default x-secret)
+	SecretQueryParam   string // SecretQueryParam, when set, is read if the header is missing
+	ProblemDetails     bool   // ProblemDetails sends the errors as RFC 9457 application/problem+json
+	ProblemTypeBaseURI string // ProblemTypeBaseURI builds the problem type from the code_name (default about:blank)
+	OpenAPI            bool   // OpenAPI serves the OpenAPI document at GET /_openapi, with the authentication of the other endpoints
 }
 
 // Endpoint is a struct that contains the endpoint's configuration and handlers
 type Endpoint struct {
 	Path              string
 	HandlerFunc       http.HandlerFunc
-	Handler           http.Handler                          // Handler is a http.Handler and is used to create a new http.Handler with the server's middlewares and endpoints
-	HandlerServerFunc func(server *Server) http.HandlerFunc // HandlerServerFunc is a function that returns a http.HandlerFunc and is used to create a new http.HandlerFunc with the server's middlewares and endpoints
+	Handler           http.Handler                                       // Handler is a http.Handler and is used to create a new http.Handler with the server's middlewares and endpoints
+	HandlerServerFunc func(server *Server) http.HandlerFunc              // HandlerServerFunc is a function that returns a http.HandlerFunc and is used to create a new http.HandlerFunc with the server's middlewares and endpoints
+	ErrorHandlerFunc  func(w http.ResponseWriter, r *http.Request) error // ErrorHandlerFunc returns the errors, which are answered with ResponseFromError
+	TypedHandler      *TypedHandler                                      // TypedHandler binds the request into a struct and encodes the result; its types describe the endpoint in OpenAPI
+	WebSocket         *WebSocketHandler                                  // WebSocket upgrades the requests to WebSocket connections after the middlewares
+	Proxy             *ProxyHandler                                      // Proxy forwards the requests to upstream targets after the middlewares
 	Options           EndpointOptions
 	RegexPattern      *regexp.Regexp
+
+	grouped bool // grouped tells that Handler wraps the other handler with the group middlewares
 }
 
 // EndpointOptions is a struct that contains the endpoint's options
@@ -42,8 +59,19 @@ type EndpointOptions struct {
 	IsPublic                 bool
 	NoRequiresAuthentication bool
 	IgnorePrefix             bool
+	RequiredScopes           []string       // RequiredScopes must all be granted to the principal
+	AnyScopes                []string       // AnyScopes require at least one of the scopes
+	RequiredRoles            []string       // RequiredRoles must all be granted to the principal
+	AnyRoles                 []string       // AnyRoles require at least one of the roles
+	CSRFExempt               bool           // CSRFExempt skips the CSRF middleware, e.g. for webhooks authenticated by signature
+	Cors                     *cors.Options  // Cors overrides Server.CorsOptions for the endpoint
+	NoCompression            bool           // NoCompression skips the Compression middleware
+	ETag                     ETagMode       // ETag enables the validators of the ConditionalRequests middleware
+	Cache                    *EndpointCache // Cache enables the ResponseCache for the GET endpoint
+	MaxBodyBytes             int64          // MaxBodyBytes limits the request body; DecodeJSON uses it instead of its default
 }
 
 type GroupOptions struct {
 	Middlewares []func(next http.Handler) http.Handler
+	Cors        *cors.Options // Cors is applied to the endpoints of the group that have no Cors of their own
 }
@@ -0,0 +1,255 @@
+package nexus
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TypedHandler is a handler whose input is bound from the request and whose output is encoded as JSON;
+// create it with Typed and register it in Endpoint.TypedHandler
+type TypedHandler struct {
+	Input   reflect.Type
+	Output  reflect.Type
+	binder  *binder
+	handler func(w http.ResponseWriter, r *http.Request)
+}
+
+// StatusCoder is implemented by outputs that are not answered with 200 OK
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// Typed adapt a function that receives the request as a struct and returns the response:
+//
+//	type GetUserReq struct {
+//		ID     string `path:"id"`
+//		Fields string `query:"fields"`
+//		Tenant string `header:"X-Tenant"`
+//	}
+//
+//	{Path: "GET /users/{id}", TypedHandler: nexus.Typed(getUser)}
+//
+// Fields without path, query or header tags are decoded from the JSON body. Binding errors are answered
+// with 400, validate tag errors with 422, the errors returned by fn with ResponseFromError, and the output
+// with ResponseWithJSON.
+func Typed[In, Out any](fn func(ctx context.Context, in In) (Out, error)) *TypedHandler {
+	input := reflect.TypeFor[In]()
+	if input.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("nexus: the input of a typed handler must be a struct, got %s", input))
+	}
+	checkValidateTags(input)
+
+	typed := &TypedHandler{Input: input, Output: reflect.TypeFor[Out](), binder: newBinder(input)}
+	typed.handler = func(w http.ResponseWriter, r *http.Request) {
+		var in In
+		if err := typed.binder.bind(r, reflect.ValueOf(&in).Elem()); err != nil {
+			ResponseFromError(w, err)
+			return
+		}
+		if err := Validate(&in); err != nil {
+			ResponseFromError(w, err)
+			return
+		}
+
+		out, err := fn(r.Context(), in)
+		if err != nil {
+			ResponseFromError(w, err)
+			return
+		}
+
+		// A nil pointer is answered with 200 and null, its StatusCode may have a value receiver
+		code := http.StatusOK
+		value := reflect.ValueOf(out)
+		if coder, ok := any(out).(StatusCoder); ok && !(value.Kind() == reflect.Pointer && value.IsNil()) {
+			code = coder.StatusCode()
+		}
+		if code == http.StatusNoContent {
+			w.WriteHeader(code)
+			return
+		}
+		ResponseWithJSON(w, code, out)
+	}
+	return typed
+}
+
+func (typed *TypedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	typed.handler(w, r)
+}
+
+// bindSources are the tags that bind a field from the request
+var bindSources = []string{"path", "query", "header"}
+
+type bindField struct {
+	index  []int
+	source string
+	name   string
+}
+
+// binder keep the fields of an input type, resolved once when the handler is created
+type binder struct {
+	fields  []bindField
+	hasBody bool
+}
+
+// newBinder walk the fields like the OpenAPI schema of the input, so both describe the same request
+func newBinder(t reflect.Type) *binder {
+	b := &binder{}
+	for _, field := range structFields(t) {
+		bound := false
+		for _, source := range bindSources {
+			if name, ok := field.Tag.Lookup(source); ok {
+				if name == "" {
+					name = field.Name
+				}
+				b.fields = append(b.fields, bindField{index: field.Index, source: source, name: name})
+				bound = true
+				break
+			}
+		}
+		if !bound && field.Tag.Get("json") != "-" {
+			b.hasBody = true
+		}
+	}
+	return b
+}
+
+func hasBindTag(field reflect.StructField) bool {
+	for _, source := range bindSources {
+		if _, ok := field.Tag.Lookup(source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bind fill dst from the JSON body and then from the path, query and header values
+func (b *binder) bind(r *http.Request, dst reflect.Value) error {
+	if b.hasBody {
+		options := DecodeOptions{AllowEmptyBody: true, DisallowTrailingData: true}
+		if err := DecodeJSON(r, dst.Addr().Interface(), options); err != nil {
+			return err
+		}
+	}
+
+	fields := make(map[string]string)
+	query := r.URL.Query()
+	for _, field := range b.fields {
+		var values []string
+		switch field.source {
+		case "path":
+			if value := r.PathValue(field.name); value != "" {
+				values = []string{value}
+			}
+		case "query":
+			values = query[field.name]
+		case "header":
+			values = r.Header.Values(field.name)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := setFieldValue(dst.FieldByIndex(field.index), values); err != nil {
+			fields[field.name] = err.Error()
+		}
+	}
+	if len(fields) > 0 {
+		return bindingError(fields)
+	}
+	return nil
+}
+
+func bindingError(fields map[string]string) *Error {
+	err := BadRequest("invalid request")
+	err.CodeName = "binding_failed"
+	err.Fields = fields
+	return err
+}
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// setFieldValue convert the values of a parameter to the type of the field
+func setFieldValue(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setFieldValue(v.Elem(), values)
+	}
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(values[0])); err != nil {
+			return errors.New("invalid value")
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		// Lists are sent as repeated parameters or separated by commas
+		var items []string
+		for _, value := range values {
+			items = append(items, strings.Split(value, ",")...)
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFieldValue(slice.Index(i), []string{strings.TrimSpace(item)}); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return setScalar(v, values[0])
+}
+
+func setScalar(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a positive integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		v.SetBytes([]byte(value))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
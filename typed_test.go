@@ -0,0 +1,197 @@
+package nexus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type updateUserReq struct {
+	ID      int           `path:"id"`
+	Notify  bool          `query:"notify"`
+	Tags    []string      `query:"tag"`
+	Timeout time.Duration `query:"timeout"`
+	Tenant  string        `header:"X-Tenant"`
+	Name    string        `json:"name"`
+	Email   *string       `json:"email,omitempty"`
+}
+
+type userResp struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Tenant string   `json:"tenant"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type createdUser struct {
+	userResp
+}
+
+func (createdUser) StatusCode() int { return http.StatusCreated }
+
+func updateUser(ctx context.Context, in updateUserReq) (userResp, error) {
+	if in.ID == 404 {
+		return userResp{}, NotFound("user not found")
+	}
+	if in.Timeout != 0 && in.Timeout != 2*time.Second {
+		return userResp{}, BadRequest("unexpected timeout")
+	}
+	return userResp{ID: in.ID, Name: in.Name, Tenant: in.Tenant, Tags: in.Tags}, nil
+}
+
+func typedServer() *Server {
+	server := &Server{ServerName: "Typed"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "PUT /users/{id}", TypedHandler: Typed(updateUser)},
+		{Path: "POST /users", TypedHandler: Typed(func(ctx context.Context, in struct {
+			Name string `json:"name"`
+		}) (createdUser, error) {
+			return createdUser{userResp{ID: 1, Name: in.Name}}, nil
+		})},
+	})
+	return server
+}
+
+func TestTyped_Binding(t *testing.T) {
+	handler := typedServer().Handler()
+
+	r := httptest.NewRequest("PUT", "/users/7?notify=true&tag=a,b&tag=c&timeout=2s", strings.NewReader(`{"name":"Ada"}`))
+	r.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var resp userResp
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.ID != 7 || resp.Name != "Ada" || resp.Tenant != "acme" || strings.Join(resp.Tags, "") != "abc" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"Grace"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+}
+
+func TestTyped_NilPointerOutput(t *testing.T) {
+	type findReq struct {
+		ID int `path:"id"`
+	}
+	typed := Typed(func(ctx context.Context, in findReq) (*createdUser, error) { return nil, nil })
+
+	r := httptest.NewRequest("GET", "/users/1", nil)
+	r.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+	typed.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "null" {
+		t.Fatalf("expected 200 with null, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTyped_Errors(t *testing.T) {
+	handler := typedServer().Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/users/abc?notify=maybe", strings.NewReader(`{}`)))
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusBadRequest || resp.CodeName != "binding_failed" {
+		t.Fatalf("expected binding error, got %d %s", w.Code, w.Body.String())
+	}
+	if resp.Errors["id"] != "must be an integer" || resp.Errors["notify"] != "must be a boolean" {
+		t.Fatalf("unexpected field errors %v", resp.Errors)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"name":`)))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusBadRequest || resp.Errors["body"] == "" {
+		t.Fatalf("expected body error, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/users/404", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 from the handler error, got %d", w.Code)
+	}
+}
+
+func TestTyped_OpenAPI(t *testing.T) {
+	server := typedServer()
+	server.Handler()
+	document := server.OpenAPI()
+
+	operation := document.Paths["/users/{id}"]["put"]
+	if operation == nil {
+		t.Fatal("expected the typed operation")
+	}
+	parameters := map[string]OpenAPIParameter{}
+	for _, parameter := range operation.Parameters {
+		parameters[parameter.In+":"+parameter.Name] = parameter
+	}
+	if parameters["path:id"].Schema["type"] != "integer" {
+		t.Fatalf("expected an integer path parameter, got %v", parameters["path:id"])
+	}
+	if parameters["query:tag"].Schema["type"] != "array" || parameters["header:X-Tenant"].Name == "" {
+		t.Fatalf("unexpected parameters %v", parameters)
+	}
+
+	body := operation.RequestBody.Content["application/json"].Schema
+	properties := body["properties"].(OpenAPISchema)
+	if _, ok := properties["name"]; !ok || len(properties) != 2 {
+		t.Fatalf("expected only the body fields, got %v", properties)
+	}
+	if required := body["required"].([]string); len(required) != 1 || required[0] != "name" {
+		t.Fatalf("unexpected required %v", required)
+	}
+
+	response := operation.Responses["200"].Content["application/json"].Schema
+	if response["$ref"] != "#/components/schemas/userResp" {
+		t.Fatalf("unexpected response schema %v", response)
+	}
+	if _, ok := document.Components.Schemas["userResp"]["properties"].(OpenAPISchema)["tags"]; !ok {
+		t.Fatal("expected the output schema in the components")
+	}
+
+	if _, ok := document.Paths["/users"]["post"].Responses["201"]; !ok {
+		t.Fatal("expected the status of the StatusCoder output")
+	}
+}
+
+type Paging struct {
+	Page int `query:"page" json:"page"`
+}
+
+func TestTyped_EmbeddedStructWithJSONName(t *testing.T) {
+	// encoding/json decodes a named embedded struct as an object, so its fields come from the body
+	type listReq struct {
+		Paging `json:"paging"`
+		Tenant string `header:"X-Tenant"`
+	}
+	typed := Typed(func(ctx context.Context, in listReq) (listReq, error) { return in, nil })
+	operation := &OpenAPIOperation{Responses: map[string]OpenAPIResponse{}}
+	describeTypedHandler(operation, typed, map[string]OpenAPISchema{})
+
+	if len(operation.Parameters) != 1 || operation.Parameters[0].Name != "X-Tenant" {
+		t.Fatalf("unexpected parameters %+v", operation.Parameters)
+	}
+	if _, ok := operation.RequestBody.Content["application/json"].Schema["properties"].(OpenAPISchema)["paging"]; !ok {
+		t.Fatalf("expected paging in the request body, got %v", operation.RequestBody)
+	}
+
+	r := httptest.NewRequest("POST", "/items?page=9", strings.NewReader(`{"paging":{"page":2}}`))
+	w := httptest.NewRecorder()
+	typed.ServeHTTP(w, r)
+	var resp listReq
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Page != 2 {
+		t.Fatalf("expected the page of the body, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,285 @@
+package nexus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// UploadedFile is a file part of a multipart request once it has been stored
+type UploadedFile struct {
+	Field       string
+	Filename    string
+	ContentType string // ContentType is sniffed from the content; the type declared by the client is ignored
+	Size        int64
+	Path        string // Path is set by TempFileSink
+	Location    string // Location is set by the custom sinks, e.g. an object key
+}
+
+// Upload contains the files and the values of a multipart request
+type Upload struct {
+	Files  []*UploadedFile
+	Values url.Values
+}
+
+// File return the first file of a field
+func (u *Upload) File(field string) (*UploadedFile, bool) {
+	for _, file := range u.Files {
+		if file.Field == field {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// UploadProgress is reported to UploadOptions.Progress while the files are read
+type UploadProgress struct {
+	Field     string
+	Filename  string
+	FileBytes int64 // FileBytes are the bytes read of the current file
+	Total     int64 // Total are the bytes read of all the files
+}
+
+// UploadSink store the content of the uploaded files; it must read content until EOF or return its error
+type UploadSink interface {
+	Store(ctx context.Context, file *UploadedFile, content io.Reader) error
+	Remove(ctx context.Context, file *UploadedFile) error
+}
+
+// UploadOptions contains the configuration of ParseUpload
+type UploadOptions struct {
+	MaxFileSize  int64    // MaxFileSize limits every file (default 32MB)
+	MaxTotalSize int64    // MaxTotalSize limits the sum of the files (default 128MB)
+	MaxFiles     int      // MaxFiles limits the number of files (default 10)
+	MaxFieldSize int64    // MaxFieldSize limits the sum of the non-file values (default 1MB)
+	AllowedTypes []string // AllowedTypes are the sniffed MIME types accepted; "image/*" matches a whole family
+	Sink         UploadSink
+	Progress     func(progress UploadProgress)
+}
+
+// TempFileSink store the files in a temporary directory; they are removed when the request ends
+type TempFileSink struct {
+	Dir string // Dir defaults to os.TempDir
+}
+
+func (s TempFileSink) Store(ctx context.Context, file *UploadedFile, content io.Reader) error {
+	f, err := os.CreateTemp(s.Dir, "nexus-upload-*")
+	if err != nil {
+		return err
+	}
+	file.Path = f.Name()
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s TempFileSink) Remove(ctx context.Context, file *UploadedFile) error {
+	if file.Path == "" {
+		return nil
+	}
+	return os.Remove(file.Path)
+}
+
+// ParseUpload stream the parts of a multipart/form-data request to the sink, without buffering the files in memory.
+// The stored files are removed with the sink when the request context ends, so handlers must move or copy
+// the files they keep. The returned *Error can be answered with ResponseFromError.
+func ParseUpload(r *http.Request, options UploadOptions) (*Upload, error) {
+	if options.MaxFileSize == 0 {
+		options.MaxFileSize = 32 << 20
+	}
+	if options.MaxTotalSize == 0 {
+		options.MaxTotalSize = 128 << 20
+	}
+	if options.MaxFiles == 0 {
+		options.MaxFiles = 10
+	}
+	if options.MaxFieldSize == 0 {
+		options.MaxFieldSize = 1 << 20
+	}
+	if options.Sink == nil {
+		options.Sink = TempFileSink{}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, decodingError(http.StatusUnsupportedMediaType, "unsupported_media_type", "content_type", "Content-Type must be multipart/form-data")
+	}
+
+	ctx := r.Context()
+	upload := &Upload{Values: url.Values{}}
+	// The files are removed with the request, including when parsing fails halfway
+	var mu sync.Mutex
+	var stored []*UploadedFile
+	cleaned := false
+	context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		cleaned = true
+		for _, file := range stored {
+			options.Sink.Remove(context.WithoutCancel(ctx), file)
+		}
+	})
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var total, fieldBytes int64
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return upload, nil
+		}
+		if err != nil {
+			return upload, uploadReadError(err)
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, options.MaxFieldSize-fieldBytes+1))
+			part.Close()
+			if err != nil {
+				return upload, uploadReadError(err)
+			}
+			fieldBytes += int64(len(value))
+			if fieldBytes > options.MaxFieldSize {
+				return upload, decodingError(http.StatusRequestEntityTooLarge, "body_too_large", part.FormName(), "the form values are too large")
+			}
+			upload.Values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if len(upload.Files) >= options.MaxFiles {
+			part.Close()
+			return upload, decodingError(http.StatusBadRequest, "too_many_files", part.FormName(), fmt.Sprintf("no more than %d files are accepted", options.MaxFiles))
+		}
+		file, err := storePart(ctx, part, &options, &total)
+		part.Close()
+		if file != nil {
+			mu.Lock()
+			if cleaned {
+				// The request ended while the file was stored, after the files were removed
+				mu.Unlock()
+				options.Sink.Remove(context.WithoutCancel(ctx), file)
+				return upload, ctx.Err()
+			}
+			stored = append(stored, file)
+			mu.Unlock()
+		}
+		if err != nil {
+			return upload, err
+		}
+		upload.Files = append(upload.Files, file)
+	}
+}
+
+// storePart sniff the type of a file part and stream it to the sink through the limits
+func storePart(ctx context.Context, part *multipart.Part, options *UploadOptions, total *int64) (*UploadedFile, error) {
+	file := &UploadedFile{Field: part.FormName(), Filename: sanitizeFilename(part.FileName())}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, uploadReadError(err)
+	}
+	head = head[:n]
+	file.ContentType = http.DetectContentType(head)
+	if !allowedUploadType(file.ContentType, options.AllowedTypes) {
+		return nil, decodingError(http.StatusUnsupportedMediaType, "unsupported_file_type", file.Field,
+			fmt.Sprintf("files of type %s are not accepted", file.ContentType))
+	}
+
+	content := &uploadReader{
+		reader:  io.MultiReader(bytes.NewReader(head), part),
+		file:    file,
+		options: options,
+		total:   total,
+	}
+	if err := options.Sink.Store(ctx, file, content); err != nil {
+		if content.err != nil {
+			return file, content.err
+		}
+		return file, uploadReadError(err)
+	}
+	return file, nil
+}
+
+func allowedUploadType(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, pattern := range allowed {
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeFilename keep only the base name that the client sent
+func sanitizeFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+func uploadReadError(err error) *Error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return decodeError(err)
+	}
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	return decodingError(http.StatusBadRequest, "invalid_multipart", "body", "invalid multipart body")
+}
+
+// uploadReader count the bytes of a file, enforcing the limits and reporting the progress
+type uploadReader struct {
+	reader  io.Reader
+	file    *UploadedFile
+	options *UploadOptions
+	total   *int64
+	err     error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n, err := u.reader.Read(p)
+	u.file.Size += int64(n)
+	*u.total += int64(n)
+
+	switch {
+	case u.file.Size > u.options.MaxFileSize:
+		u.err = decodingError(http.StatusRequestEntityTooLarge, "file_too_large", u.file.Field,
+			fmt.Sprintf("the file must not be larger than %d bytes", u.options.MaxFileSize))
+	case *u.total > u.options.MaxTotalSize:
+		u.err = decodingError(http.StatusRequestEntityTooLarge, "body_too_large", u.file.Field,
+			fmt.Sprintf("the files must not be larger than %d bytes", u.options.MaxTotalSize))
+	}
+	if u.err != nil {
+		return 0, u.err
+	}
+
+	if n > 0 && u.options.Progress != nil {
+		u.options.Progress(UploadProgress{Field: u.file.Field, Filename: u.file.Filename, FileBytes: u.file.Size, Total: *u.total})
+	}
+	return n, err
+}
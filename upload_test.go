@@ -0,0 +1,191 @@
+package nexus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+type uploadPart struct {
+	field, filename string
+	content         []byte
+}
+
+func multipartRequest(t *testing.T, parts ...uploadPart) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if part.filename == "" {
+			writer.WriteField(part.field, string(part.content))
+			continue
+		}
+		w, _ := writer.CreateFormFile(part.field, part.filename)
+		w.Write(part.content)
+	}
+	writer.Close()
+	r := httptest.NewRequest("POST", "/documents", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestParseUpload_TempFiles(t *testing.T) {
+	image := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 2000)...)
+	r := multipartRequest(t,
+		uploadPart{field: "title", content: []byte("Report")},
+		uploadPart{field: "image", filename: "../../photo.png", content: image},
+		uploadPart{field: "notes", filename: "notes.txt", content: []byte("plain text notes")},
+	)
+	ctx, cancel := context.WithCancel(r.Context())
+	r = r.WithContext(ctx)
+
+	var progress []UploadProgress
+	upload, err := ParseUpload(r, UploadOptions{
+		AllowedTypes: []string{"image/*", "text/plain"},
+		Sink:         TempFileSink{Dir: t.TempDir()},
+		Progress:     func(p UploadProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if upload.Values.Get("title") != "Report" || len(upload.Files) != 2 {
+		t.Fatalf("unexpected upload %+v", upload)
+	}
+	file, _ := upload.File("image")
+	if file.Filename != "photo.png" || file.ContentType != "image/png" || file.Size != int64(len(image)) {
+		t.Fatalf("unexpected file %+v", file)
+	}
+	stored, err := os.ReadFile(file.Path)
+	if err != nil || !bytes.Equal(stored, image) {
+		t.Fatal("expected the content in the temp file")
+	}
+	if last := progress[len(progress)-1]; last.Total != int64(len(image))+16 || last.Filename != "notes.txt" {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+
+	cancel()
+	deadline := 100
+	for ; deadline > 0; deadline-- {
+		if _, err := os.Stat(file.Path); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if deadline == 0 {
+		t.Fatal("expected the temp file to be removed when the request ends")
+	}
+}
+
+func TestParseUpload_Limits(t *testing.T) {
+	big := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 4096)...)
+	cases := []struct {
+		name     string
+		options  UploadOptions
+		parts    []uploadPart
+		status   int
+		codeName string
+	}{
+		{"file size", UploadOptions{MaxFileSize: 1024}, []uploadPart{{"a", "a.png", big}}, 413, "file_too_large"},
+		{"total size", UploadOptions{MaxTotalSize: 6000}, []uploadPart{{"a", "a.png", big}, {"b", "b.png", big}}, 413, "body_too_large"},
+		{"files", UploadOptions{MaxFiles: 1}, []uploadPart{{"a", "a.png", big}, {"b", "b.png", big}}, 400, "too_many_files"},
+		{"type", UploadOptions{AllowedTypes: []string{"application/pdf"}}, []uploadPart{{"a", "fake.pdf", big}}, 415, "unsupported_file_type"},
+		{"fields", UploadOptions{MaxFieldSize: 8}, []uploadPart{{"comment", "", []byte(strings.Repeat("x", 20))}}, 413, "body_too_large"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.options.Sink = TempFileSink{Dir: t.TempDir()}
+			_, err := ParseUpload(multipartRequest(t, c.parts...), c.options)
+			var typed *Error
+			if !errors.As(err, &typed) || typed.Status != c.status || typed.CodeName != c.codeName {
+				t.Fatalf("expected %d %s, got %v", c.status, c.codeName, err)
+			}
+		})
+	}
+
+	_, err := ParseUpload(httptest.NewRequest("POST", "/documents", strings.NewReader("{}")), UploadOptions{})
+	var typed *Error
+	if !errors.As(err, &typed) || typed.Status != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for non multipart requests, got %v", err)
+	}
+}
+
+type memorySink struct {
+	files   map[string][]byte
+	removed []string
+}
+
+func (s *memorySink) Store(ctx context.Context, file *UploadedFile, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	file.Location = "mem://" + file.Filename
+	s.files[file.Location] = data
+	return nil
+}
+
+func (s *memorySink) Remove(ctx context.Context, file *UploadedFile) error {
+	s.removed = append(s.removed, file.Location)
+	return nil
+}
+
+func TestParseUpload_CustomSink(t *testing.T) {
+	sink := &memorySink{files: map[string][]byte{}}
+	upload, err := ParseUpload(multipartRequest(t, uploadPart{"doc", "doc.txt", []byte("hello")}), UploadOptions{Sink: sink})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if upload.Files[0].Location != "mem://doc.txt" || string(sink.files["mem://doc.txt"]) != "hello" {
+		t.Fatalf("unexpected sink state %+v", upload.Files[0])
+	}
+}
+
+// cancelingSink end the request while the file is being stored
+type cancelingSink struct {
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	removed []string
+}
+
+func (s *cancelingSink) Store(ctx context.Context, file *UploadedFile, content io.Reader) error {
+	io.Copy(io.Discard, content)
+	file.Location = "mem://" + file.Filename
+	s.cancel()
+	// Give the cleanup of the request the time to run
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func (s *cancelingSink) Remove(ctx context.Context, file *UploadedFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = append(s.removed, file.Location)
+	return nil
+}
+
+func TestParseUpload_RequestEndsWhileStoring(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &cancelingSink{cancel: cancel}
+	r := multipartRequest(t, uploadPart{"doc", "doc.txt", []byte("hello")}).WithContext(ctx)
+	if _, err := ParseUpload(r, UploadOptions{Sink: sink}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.removed) != 1 || sink.removed[0] != "mem://doc.txt" {
+		t.Fatalf("expected the file stored after the cleanup to be removed, got %v", sink.removed)
+	}
+}
@@ -0,0 +1,318 @@
+package nexus
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidatorFunc check a field for a custom rule; param is the text after "=" in the tag
+type ValidatorFunc func(field reflect.Value, param string) error
+
+var (
+	validatorsMu sync.RWMutex
+	validators   = map[string]ValidatorFunc{}
+
+	validationRules sync.Map // reflect.Type -> []fieldRules
+
+	uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// RegisterValidator add a rule that can be used in validate tags, e.g. validate:"required,slug"
+func RegisterValidator(name string, validator ValidatorFunc) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators[name] = validator
+}
+
+type rule struct {
+	name  string
+	param string
+	limit float64        // limit is the parsed param of min, max and len
+	regex *regexp.Regexp // regex is the compiled param of regex
+}
+
+type fieldRules struct {
+	index    []int
+	name     string // name is the JSON name of the field
+	embedded bool   // embedded fields are flattened like encoding/json does
+	rules    []rule
+}
+
+// Validate check the validate tags of a struct, and of the nested structs and slices of structs:
+//
+//	type CreateUserReq struct {
+//		Name  string   `json:"name" validate:"required,min=2,max=50"`
+//		Email string   `json:"email" validate:"required,email"`
+//		Role  string   `json:"role" validate:"oneof=admin editor viewer"`
+//		Tags  []string `json:"tags" validate:"max=5"`
+//		Code  string   `json:"code" validate:"regex=^[A-Z]{3}$"`
+//	}
+//
+// Empty optional fields are not checked. regex must be the last rule of a tag, since the expression may
+// contain commas. It returns a 422 *Error with the messages keyed by JSON path, e.g. "items[0].name", or nil.
+// Unknown rules, non numeric sizes and invalid expressions panic; Typed checks them when the handler is created.
+func Validate(v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	fields := make(map[string]string)
+	validateValue(value, "", fields)
+	if len(fields) > 0 {
+		return Validation("validation failed", fields)
+	}
+	return nil
+}
+
+// validateValue descend into structs, slices and maps looking for validate tags
+func validateValue(value reflect.Value, path string, fields map[string]string) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return
+		}
+		for _, field := range structRules(value.Type()) {
+			fieldValue := value.FieldByIndex(field.index)
+			fieldPath := joinPath(path, field.name)
+			if field.embedded {
+				fieldPath = path
+			}
+			if msg := checkRules(fieldValue, field.rules); msg != "" {
+				fields[fieldPath] = msg
+				continue
+			}
+			validateValue(fieldValue, fieldPath, fields)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), joinPath(path, fmt.Sprint(iter.Key().Interface())), fields)
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// structRules parse the tags of a type once
+func structRules(t reflect.Type) []fieldRules {
+	if cached, ok := validationRules.Load(t); ok {
+		return cached.([]fieldRules)
+	}
+
+	var rules []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		embedded := field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == ""
+		if !field.IsExported() && !embedded {
+			continue
+		}
+		name, _, skip := jsonFieldName(field)
+		if skip {
+			name = field.Name
+		}
+		for _, source := range bindSources {
+			if value, ok := field.Tag.Lookup(source); ok && value != "" {
+				name = value
+			}
+		}
+		parsed, err := parseRules(field.Tag.Get("validate"))
+		if err != nil {
+			panic(fmt.Sprintf("nexus: invalid validate tag of %s.%s: %v", t, field.Name, err))
+		}
+		rules = append(rules, fieldRules{index: field.Index, name: name, embedded: embedded, rules: parsed})
+	}
+	validationRules.Store(t, rules)
+	return rules
+}
+
+// parseRules split a validate tag and check its rules; regex must be the last rule since the expression may contain commas
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(strings.TrimSpace(tag), "regex=") {
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+
+		r := rule{name: name, param: param}
+		switch name {
+		case "required", "email", "url", "uuid", "oneof":
+		case "min", "max", "len":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s parameter %q", name, param)
+			}
+			r.limit = limit
+		case "regex":
+			compiled, err := regexp.Compile(param)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex: %v", err)
+			}
+			r.regex = compiled
+		default:
+			validatorsMu.RLock()
+			_, ok := validators[name]
+			validatorsMu.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown validation rule %q", name)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkValidateTags parse the validate tags of a type and of the types it contains, so a wrong tag panics
+// when the handler is created instead of on the first request
+func checkValidateTags(t reflect.Type) {
+	checkTypeTags(t, map[reflect.Type]bool{})
+}
+
+func checkTypeTags(t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || seen[t] {
+		return
+	}
+	seen[t] = true
+	for _, field := range structRules(t) {
+		checkTypeTags(t.FieldByIndex(field.index).Type, seen)
+	}
+}
+
+// checkRules return the message of the first rule the value breaks
+func checkRules(value reflect.Value, rules []rule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	if value.IsZero() {
+		for _, r := range rules {
+			if r.name == "required" {
+				return "is required"
+			}
+		}
+		return ""
+	}
+
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+	for _, r := range rules {
+		if msg := checkRule(value, r); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(value reflect.Value, r rule) string {
+	switch r.name {
+	case "required":
+		return ""
+	case "min", "max", "len":
+		return checkSize(value, r)
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be a valid email address"
+		}
+	case "url":
+		parsed, err := url.Parse(value.String())
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return "must be a valid URL"
+		}
+	case "uuid":
+		if !uuidRegex.MatchString(value.String()) {
+			return "must be a valid UUID"
+		}
+	case "oneof":
+		options := strings.Fields(r.param)
+		if !contains(options, fmt.Sprint(value.Interface())) {
+			return "must be one of: " + strings.Join(options, ", ")
+		}
+	case "regex":
+		if !r.regex.MatchString(value.String()) {
+			return "has an invalid format"
+		}
+	default:
+		validatorsMu.RLock()
+		// parseRules has checked that the rule is registered
+		validator := validators[r.name]
+		validatorsMu.RUnlock()
+		if err := validator(value, r.param); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// checkSize compare numbers with their value and strings, slices and maps with their length
+func checkSize(value reflect.Value, r rule) string {
+	limit := r.limit
+	var size float64
+	unit := ""
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	default:
+		return ""
+	}
+
+	param := strconv.FormatFloat(limit, 'f', -1, 64)
+	switch {
+	case r.name == "min" && size < limit:
+		if unit == "" {
+			return "must be at least " + param
+		}
+		return "must have at least " + param + unit
+	case r.name == "max" && size > limit:
+		if unit == "" {
+			return "must be at most " + param
+		}
+		return "must have at most " + param + unit
+	case r.name == "len" && size != limit:
+		return "must have exactly " + param + unit
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package nexus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type orderItem struct {
+	SKU      string `json:"sku" validate:"required,regex=^[A-Z]{3}-[0-9]+$"`
+	Quantity int    `json:"quantity" validate:"min=1,max=99"`
+}
+
+type auditInfo struct {
+	Source string `json:"source" validate:"oneof=web mobile"`
+}
+
+type createOrderReq struct {
+	auditInfo
+	ID       string      `json:"id" validate:"uuid"`
+	Email    string      `json:"email" validate:"required,email"`
+	Callback string      `json:"callback" validate:"url"`
+	Country  string      `json:"country" validate:"len=2"`
+	Coupon   *string     `json:"coupon" validate:"min=4"`
+	Items    []orderItem `json:"items" validate:"required,max=3"`
+	Slug     string      `json:"slug" validate:"slug"`
+	Notes    []string    `json:"-"`
+}
+
+func init() {
+	RegisterValidator("slug", func(field reflect.Value, param string) error {
+		if strings.ToLower(field.String()) != field.String() || strings.Contains(field.String(), " ") {
+			return errors.New("must be a lowercase slug")
+		}
+		return nil
+	})
+}
+
+func validationFields(t *testing.T, v any) map[string]string {
+	t.Helper()
+	err := Validate(v)
+	if err == nil {
+		return nil
+	}
+	var typed *Error
+	if !errors.As(err, &typed) || typed.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422 *Error, got %v", err)
+	}
+	return typed.Fields
+}
+
+func TestValidate(t *testing.T) {
+	coupon := "AB"
+	req := createOrderReq{
+		auditInfo: auditInfo{Source: "fax"},
+		ID:        "not-a-uuid",
+		Email:     "ada@",
+		Callback:  "/relative",
+		Country:   "ESP",
+		Coupon:    &coupon,
+		Items:     []orderItem{{SKU: "ABC-1", Quantity: 1}, {SKU: "bad", Quantity: 100}},
+		Slug:      "Not A Slug",
+	}
+
+	fields := validationFields(t, &req)
+	expected := map[string]string{
+		"source":            "must be one of: web, mobile",
+		"id":                "must be a valid UUID",
+		"email":             "must be a valid email address",
+		"callback":          "must be a valid URL",
+		"country":           "must have exactly 2 characters",
+		"coupon":            "must have at least 4 characters",
+		"items[1].sku":      "has an invalid format",
+		"items[1].quantity": "must be at most 99",
+		"slug":              "must be a lowercase slug",
+	}
+	for path, msg := range expected {
+		if fields[path] != msg {
+			t.Fatalf("%s: expected %q, got %q (all: %v)", path, msg, fields[path], fields)
+		}
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+}
+
+func TestValidate_RequiredAndOptional(t *testing.T) {
+	fields := validationFields(t, createOrderReq{})
+	if len(fields) != 2 || fields["email"] != "is required" || fields["items"] != "is required" {
+		t.Fatalf("expected only the required fields, got %v", fields)
+	}
+
+	valid := createOrderReq{
+		ID:    "0b6c1a52-4d0e-4a5f-9a3f-2f0c7c2b8e11",
+		Email: "ada@example.com",
+		Items: []orderItem{{SKU: "ABC-12", Quantity: 2}},
+	}
+	if err := Validate(valid); err != nil {
+		t.Fatalf("expected a valid request, got %v", err)
+	}
+}
+
+func TestTyped_Validation(t *testing.T) {
+	server := &Server{ServerName: "Validation"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "POST /orders", TypedHandler: Typed(func(ctx context.Context, in createOrderReq) (orderItem, error) {
+			return in.Items[0], nil
+		})},
+	})
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/orders", strings.NewReader(`{"email":"ada@example.com","items":[{"sku":"x"}]}`)))
+
+	var resp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusUnprocessableEntity || resp.CodeName != "validation_failed" {
+		t.Fatalf("expected 422, got %d %s", w.Code, w.Body.String())
+	}
+	if resp.Errors["items[0].sku"] != "has an invalid format" {
+		t.Fatalf("unexpected errors %v", resp.Errors)
+	}
+}
+
+func TestTyped_InvalidValidateTags(t *testing.T) {
+	type unknownRule struct {
+		Name string `json:"name" validate:"required,slugg"`
+	}
+	type badSize struct {
+		Name string `json:"name" validate:"max=ten"`
+	}
+	type badRegex struct {
+		Items []struct {
+			Code string `json:"code" validate:"regex=^[A-Z"`
+		} `json:"items"`
+	}
+
+	tests := map[string]func(){
+		"unknown rule": func() { Typed(func(ctx context.Context, in unknownRule) (string, error) { return "", nil }) },
+		"bad size":     func() { Typed(func(ctx context.Context, in badSize) (string, error) { return "", nil }) },
+		"bad regex":    func() { Typed(func(ctx context.Context, in badRegex) (string, error) { return "", nil }) },
+	}
+	for name, create := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Typed must panic when the handler is created", name)
+				}
+			}()
+			create()
+		}()
+	}
+}
@@ -0,0 +1,701 @@
+package nexus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// webSocketGUID is appended to Sec-WebSocket-Key to build Sec-WebSocket-Accept (RFC 6455 section 1.3)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The message types of WebSocketConn, which are the opcodes of RFC 6455
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	continuationFrame = 0
+	closeFrame        = 8
+	pingFrame         = 9
+	pongFrame         = 10
+)
+
+// The close codes of RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalServerErr  = 1011
+)
+
+// ErrWebSocketClosed is returned by the writes after the connection has been closed
+var ErrWebSocketClosed = errors.New("websocket: the connection is closed")
+
+// CloseError is returned by ReadMessage when the connection is closed by the client, or by the server
+// because the client broke the protocol
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// WebSocketOptions contains the configuration of a WebSocket endpoint
+type WebSocketOptions struct {
+	Subprotocols         []string      // Subprotocols are the protocols supported, chosen in the order of preference of the client
+	ReadLimit            int64         // ReadLimit is the maximum size of a message, decompressed (default 1MB)
+	PingInterval         time.Duration // PingInterval is the interval of the keepalive pings; a client silent for two intervals is disconnected (default 30s, negative disables)
+	WriteTimeout         time.Duration // WriteTimeout limits every write (default 10s)
+	Compression          bool          // Compression negotiates permessage-deflate (RFC 7692)
+	CompressionLevel     int           // CompressionLevel is a compress/flate level (default flate.DefaultCompression)
+	CompressionThreshold int           // CompressionThreshold is the size from which messages are compressed (default 256)
+	FragmentSize         int           // FragmentSize, when set, splits the larger messages into frames of that size
+}
+
+// WebSocketHandler upgrade the requests of an endpoint to WebSocket connections
+type WebSocketHandler struct {
+	Options WebSocketOptions
+	handler func(conn *WebSocketConn)
+	server  *Server
+}
+
+// WebSocket create the handler of a WebSocket endpoint; the server middlewares, such as the authentication,
+// run before the upgrade, and the connection is closed when the handler returns:
+//
+//	{
+//		Path: "GET /ws/jobs",
+//		WebSocket: nexus.WebSocket(func(conn *nexus.WebSocketConn) {
+//			for {
+//				_, message, err := conn.ReadMessage()
+//				if err != nil {
+//					return
+//				}
+//				conn.WriteMessage(nexus.TextMessage, message)
+//			}
+//		}, nexus.WebSocketOptions{Compression: true}),
+//	}
+//
+// Browsers cannot be limited by CORS, so the Origin is checked against the CorsOptions of the endpoint, or
+// Server.CorsOptions: the same origin, AllowedOrigins (with "*" wildcards) and the AllowOrigin functions are
+// accepted. Unlike CORS, no AllowedOrigins means the same origin only. Requests without Origin, which do not
+// come from browsers, are accepted.
+func WebSocket(handler func(conn *WebSocketConn), options WebSocketOptions) *WebSocketHandler {
+	if options.ReadLimit == 0 {
+		options.ReadLimit = 1 << 20
+	}
+	if options.PingInterval == 0 {
+		options.PingInterval = 30 * time.Second
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = 10 * time.Second
+	}
+	if options.CompressionLevel == 0 {
+		options.CompressionLevel = flate.DefaultCompression
+	}
+	if options.CompressionThreshold == 0 {
+		options.CompressionThreshold = 256
+	}
+	return &WebSocketHandler{Options: options, handler: handler}
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrade(w, r)
+	if err != nil {
+		ResponseFromError(w, err)
+		return
+	}
+	if conn == nil {
+		return
+	}
+	defer conn.Close(CloseNormalClosure, "")
+	h.handler(conn)
+}
+
+// upgrade validate the handshake and hijack the connection; the errors are answered before the hijack,
+// and a nil connection without error means the client left during the handshake
+func (h *WebSocketHandler) upgrade(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, NewError(http.StatusMethodNotAllowed, "the WebSocket handshake must use GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return nil, NewError(http.StatusUpgradeRequired, "the request is not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewError(http.StatusUpgradeRequired, "only the version 13 of WebSocket is supported")
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, BadRequest("invalid Sec-WebSocket-Key").WithCode("invalid_websocket_key")
+	}
+	if !h.originAllowed(r) {
+		return nil, Forbidden("the origin is not allowed").WithCode("origin_not_allowed")
+	}
+
+	subprotocol := negotiateSubprotocol(r.Header, h.Options.Subprotocols)
+	compress := h.Options.Compression && negotiateDeflate(r.Header)
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, Internal(err)
+	}
+	// The server timeouts are replaced by the keepalive of the connection
+	netConn.SetDeadline(time.Time{})
+
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	response.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n")
+	if subprotocol != "" {
+		response.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		// The contexts are never kept, so both sides compress every message on its own
+		response.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	response.WriteString("\r\n")
+
+	netConn.SetWriteDeadline(time.Now().Add(h.Options.WriteTimeout))
+	if _, err := netConn.Write([]byte(response.String())); err != nil {
+		// The connection is hijacked, so the failure cannot be answered
+		netConn.Close()
+		return nil, nil
+	}
+	netConn.SetWriteDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	conn := &WebSocketConn{
+		Subprotocol: subprotocol,
+		request:     r,
+		conn:        netConn,
+		reader:      rw.Reader,
+		options:     h.Options,
+		compress:    compress,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if h.Options.PingInterval > 0 {
+		go conn.keepalive()
+	}
+	return conn, nil
+}
+
+// originAllowed apply the CORS origins of the endpoint to the handshake
+func (h *WebSocketHandler) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || strings.EqualFold(origin, RequestScheme(r)+"://"+r.Host) {
+		return true
+	}
+	if h.server == nil {
+		return false
+	}
+
+	options := h.server.CorsOptions
+	if endpoint, ok := h.server.GetEndpoint(r); ok && endpoint.Options.Cors != nil {
+		options = *endpoint.Options.Cors
+	}
+	switch {
+	case options.AllowOriginVaryRequestFunc != nil:
+		allowed, _ := options.AllowOriginVaryRequestFunc(r, origin)
+		return allowed
+	case options.AllowOriginRequestFunc != nil:
+		return options.AllowOriginRequestFunc(r, origin)
+	case options.AllowOriginFunc != nil:
+		return options.AllowOriginFunc(origin)
+	}
+	for _, pattern := range options.AllowedOrigins {
+		if pattern == "*" || matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken evaluate if a comma separated header contains the token, ignoring the case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiateSubprotocol return the first protocol requested by the client that the server supports
+func negotiateSubprotocol(header http.Header, supported []string) string {
+	for _, value := range header.Values("Sec-WebSocket-Protocol") {
+		for _, requested := range strings.Split(value, ",") {
+			requested = strings.TrimSpace(requested)
+			for _, protocol := range supported {
+				if requested == protocol {
+					return protocol
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// negotiateDeflate accept the first permessage-deflate offer that can be honoured; compress/flate always uses
+// a window of 15 bits, so offers that limit the window of the server are declined
+func negotiateDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			accepted := true
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				switch strings.TrimSpace(name) {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				case "server_max_window_bits":
+					accepted = accepted && strings.Trim(strings.TrimSpace(value), `"`) == "15"
+				default:
+					accepted = false
+				}
+			}
+			if accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketConn is an upgraded connection; it supports one reader and many concurrent writers.
+// The pings and the close frames of the client are answered while the connection is read.
+type WebSocketConn struct {
+	Subprotocol string // Subprotocol is the protocol negotiated with the client, if any
+
+	request  *http.Request
+	conn     net.Conn
+	reader   *bufio.Reader
+	options  WebSocketOptions
+	compress bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	writeMu   sync.Mutex
+	closeSent bool
+	deflater  *flate.Writer
+	closeOnce sync.Once
+	readErr   error
+}
+
+// Request return the upgraded request, with the values that the middlewares set in its context
+func (c *WebSocketConn) Request() *http.Request {
+	return c.request
+}
+
+// Context is canceled when the connection is closed
+func (c *WebSocketConn) Context() context.Context {
+	return c.ctx
+}
+
+// ReadMessage return the next text or binary message, reassembling the fragments and decompressing it.
+// A *CloseError is returned when the client closes the connection or breaks the protocol.
+func (c *WebSocketConn) ReadMessage() (int, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, message, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		c.closeConn()
+	}
+	return messageType, message, err
+}
+
+// ReadJSON read the next message into v
+func (c *WebSocketConn) ReadJSON(v any) error {
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(message, v)
+}
+
+// WriteMessage send a text or binary message
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	compressed := false
+	if c.compress && len(data) >= c.options.CompressionThreshold {
+		deflated, err := c.deflate(data)
+		if err != nil {
+			return err
+		}
+		data, compressed = deflated, true
+	}
+
+	size := c.options.FragmentSize
+	if size <= 0 || len(data) <= size {
+		return c.writeFrame(true, compressed, messageType, data)
+	}
+	opcode := messageType
+	for len(data) > 0 {
+		n := min(size, len(data))
+		// Only the first frame of a message carries its type and the compression bit
+		if err := c.writeFrame(n == len(data), compressed && opcode != continuationFrame, opcode, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		opcode = continuationFrame
+	}
+	return nil
+}
+
+// WriteJSON send v as a text message
+func (c *WebSocketConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// Ping send a ping; the pong of the client keeps the connection alive
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.writeControl(pingFrame, data)
+}
+
+// Close send a close frame with the code and the reason, and close the connection
+func (c *WebSocketConn) Close(code int, reason string) error {
+	err := c.writeControl(closeFrame, closePayload(code, reason))
+	c.closeConn()
+	if errors.Is(err, ErrWebSocketClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *WebSocketConn) closeConn() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.conn.Close()
+	})
+}
+
+func (c *WebSocketConn) keepalive() {
+	ticker := time.NewTicker(c.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if c.Ping(nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *WebSocketConn) readMessage() (int, []byte, error) {
+	var message []byte
+	messageType := 0
+	compressed := false
+
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case pingFrame:
+			if err := c.writeControl(pongFrame, frame.payload); err != nil && !errors.Is(err, ErrWebSocketClosed) {
+				return 0, nil, err
+			}
+			continue
+		case pongFrame:
+			continue
+		case closeFrame:
+			return 0, nil, c.closed(frame.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "a fragmented message was not finished")
+			}
+			messageType, compressed = frame.opcode, frame.rsv1
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if frame.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "only the first frame of a message is compressed")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode "+strconv.Itoa(frame.opcode))
+		}
+
+		if int64(len(message)+len(frame.payload)) > c.options.ReadLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, "the message is too big")
+		}
+		message = append(message, frame.payload...)
+		if frame.fin {
+			break
+		}
+	}
+
+	if compressed {
+		inflated, err := inflate(message, c.options.ReadLimit)
+		if err != nil {
+			if errors.Is(err, errMessageTooBig) {
+				return 0, nil, c.fail(CloseMessageTooBig, "the message is too big")
+			}
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid compressed message")
+		}
+		message = inflated
+	}
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(CloseInvalidPayload, "text messages must be UTF-8")
+	}
+	return messageType, message, nil
+}
+
+type webSocketFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	payload []byte
+}
+
+func (c *WebSocketConn) readFrame() (webSocketFrame, error) {
+	var frame webSocketFrame
+	if c.options.PingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.options.PingInterval))
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame, err
+	}
+	frame.fin = header[0]&0x80 != 0
+	frame.rsv1 = header[0]&0x40 != 0
+	frame.opcode = int(header[0] & 0x0f)
+	if header[0]&0x30 != 0 || (frame.rsv1 && !c.compress) {
+		return frame, c.fail(CloseProtocolError, "reserved bits must be 0")
+	}
+	if header[1]&0x80 == 0 {
+		return frame, c.fail(CloseProtocolError, "the frames of the client must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if frame.opcode >= closeFrame {
+		if !frame.fin || length > 125 || frame.rsv1 {
+			return frame, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	}
+	if length > uint64(c.options.ReadLimit) {
+		return frame, c.fail(CloseMessageTooBig, "the message is too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return frame, err
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		return frame, err
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// closed answer the close frame of the client and return its code
+func (c *WebSocketConn) closed(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, "the close reason must be UTF-8")
+		}
+	}
+
+	var reply []byte
+	if closeErr.Code != CloseNoStatusReceived {
+		reply = closePayload(closeErr.Code, "")
+	}
+	c.writeControl(closeFrame, reply)
+	c.closeConn()
+	return closeErr
+}
+
+// fail close the connection because the client broke the protocol
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.writeControl(closeFrame, closePayload(code, reason))
+	c.closeConn()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// validCloseCode evaluate if a code can be sent in a close frame (RFC 6455 section 7.4)
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= CloseNormalClosure && code <= 1014:
+		// 1004 is reserved, 1005 and 1006 are never sent
+		return code != 1004 && code != CloseNoStatusReceived && code != CloseAbnormalClosure
+	}
+	return false
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	// Control frames are limited to 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+func (c *WebSocketConn) writeControl(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(true, false, opcode, payload)
+}
+
+// writeFrame send an unmasked frame; it must be called with writeMu held
+func (c *WebSocketConn) writeFrame(fin bool, rsv1 bool, opcode int, payload []byte) error {
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, first)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	if opcode == closeFrame {
+		c.closeSent = true
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// deflate compress a message without the empty block that ends every flush (RFC 7692 section 7.2.1);
+// it must be called with writeMu held
+func (c *WebSocketConn) deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if c.deflater == nil {
+		deflater, err := flate.NewWriter(&buf, c.options.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		c.deflater = deflater
+	} else {
+		c.deflater.Reset(&buf)
+	}
+	if _, err := c.deflater.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.deflater.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}), nil
+}
+
+var errMessageTooBig = errors.New("websocket: message too big")
+
+// deflateTail restore the end of the flush and add a final empty block, so the reader reaches EOF
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+func inflate(data []byte, limit int64) ([]byte, error) {
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer reader.Close()
+	message, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > limit {
+		return nil, errMessageTooBig
+	}
+	return message, nil
+}
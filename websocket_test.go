@@ -0,0 +1,314 @@
+package nexus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/cors"
+)
+
+// wsClient is a minimal client that writes masked frames and reads the frames of the server
+type wsClient struct {
+	t        *testing.T
+	conn     net.Conn
+	reader   *bufio.Reader
+	response *http.Response
+}
+
+func dialWebSocket(t *testing.T, url string, header http.Header) *wsClient {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{t: t, conn: conn, reader: reader, response: resp}
+}
+
+func (c *wsClient) writeFrame(fin bool, rsv1 bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) readFrame() (fin bool, rsv1 bool, opcode int, payload []byte) {
+	c.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint64(extended[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		c.t.Fatalf("read payload: %v", err)
+	}
+	return header[0]&0x80 != 0, header[0]&0x40 != 0, int(header[0] & 0x0f), payload
+}
+
+func (c *wsClient) expectClose(code int) {
+	c.t.Helper()
+	_, _, opcode, payload := c.readFrame()
+	if opcode != closeFrame || len(payload) < 2 {
+		c.t.Fatalf("expected a close frame, got opcode %d %q", opcode, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		c.t.Errorf("close code = %d (%s), want %d", got, payload[2:], code)
+	}
+}
+
+func echoWebSocket(options WebSocketOptions) *WebSocketHandler {
+	return WebSocket(func(conn *WebSocketConn) {
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, message)
+		}
+	}, options)
+}
+
+func webSocketServer(t *testing.T, server *Server) *httptest.Server {
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestWebSocket_HandshakeAndEcho(t *testing.T) {
+	server := &Server{ServerName: "WebSocket"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /ws", WebSocket: echoWebSocket(WebSocketOptions{Subprotocols: []string{"chat.v2", "chat.v1"}})},
+	})
+	ts := webSocketServer(t, server)
+
+	client := dialWebSocket(t, ts.URL+"/ws", http.Header{"Sec-Websocket-Protocol": {"chat.v1, chat.v2"}})
+	if client.response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", client.response.StatusCode)
+	}
+	// The example of RFC 6455 section 1.3
+	if accept := client.response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", accept)
+	}
+	if protocol := client.response.Header.Get("Sec-WebSocket-Protocol"); protocol != "chat.v1" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want the preference of the client", protocol)
+	}
+
+	client.writeFrame(true, false, TextMessage, []byte("hello"))
+	if _, _, opcode, payload := client.readFrame(); opcode != TextMessage || string(payload) != "hello" {
+		t.Errorf("echo = %d %q", opcode, payload)
+	}
+
+	// A fragmented message with a ping between the fragments
+	client.writeFrame(false, false, BinaryMessage, []byte("frag"))
+	client.writeFrame(true, false, pingFrame, []byte("keepalive"))
+	client.writeFrame(true, false, continuationFrame, []byte("mented"))
+	if _, _, opcode, payload := client.readFrame(); opcode != pongFrame || string(payload) != "keepalive" {
+		t.Errorf("pong = %d %q", opcode, payload)
+	}
+	if _, _, opcode, payload := client.readFrame(); opcode != BinaryMessage || string(payload) != "fragmented" {
+		t.Errorf("reassembled = %d %q", opcode, payload)
+	}
+
+	client.writeFrame(true, false, closeFrame, closePayload(CloseGoingAway, "bye"))
+	client.expectClose(CloseGoingAway)
+}
+
+func TestWebSocket_HandshakeErrors(t *testing.T) {
+	handler := echoWebSocket(WebSocketOptions{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/ws", nil))
+	if w.Code != http.StatusUpgradeRequired {
+		t.Errorf("plain request: status = %d, want 426", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "8")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUpgradeRequired || w.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("old version: status = %d, version = %q", w.Code, w.Header().Get("Sec-WebSocket-Version"))
+	}
+
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "short")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_websocket_key") {
+		t.Errorf("invalid key: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebSocket_OriginsAndMiddlewares(t *testing.T) {
+	server := &Server{
+		ServerName:  "WebSocket",
+		CorsOptions: cors.Options{AllowedOrigins: []string{"https://*.example.com"}},
+	}
+	server.Use(func(next http.Handler, server *Server) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("token") != "valid" {
+				ResponseFromError(w, Unauthorized("missing token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /ws", WebSocket: echoWebSocket(WebSocketOptions{})},
+	})
+	ts := webSocketServer(t, server)
+
+	if client := dialWebSocket(t, ts.URL+"/ws", nil); client.response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401 before the upgrade", client.response.StatusCode)
+	}
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"https://app.example.com", http.StatusSwitchingProtocols},
+		{"", http.StatusSwitchingProtocols},
+		{"https://evil.io", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		client := dialWebSocket(t, ts.URL+"/ws?token=valid", header)
+		if client.response.StatusCode != tt.status {
+			t.Errorf("origin %q: status = %d, want %d", tt.origin, client.response.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestWebSocket_ProtocolErrors(t *testing.T) {
+	server := &Server{ServerName: "WebSocket"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /ws", WebSocket: echoWebSocket(WebSocketOptions{ReadLimit: 16})},
+	})
+	ts := webSocketServer(t, server)
+
+	client := dialWebSocket(t, ts.URL+"/ws", nil)
+	client.writeFrame(true, false, TextMessage, bytes.Repeat([]byte("a"), 17))
+	client.expectClose(CloseMessageTooBig)
+
+	client = dialWebSocket(t, ts.URL+"/ws", nil)
+	client.writeFrame(true, false, TextMessage, []byte{0xff, 0xfe})
+	client.expectClose(CloseInvalidPayload)
+
+	client = dialWebSocket(t, ts.URL+"/ws", nil)
+	client.writeFrame(true, false, continuationFrame, []byte("orphan"))
+	client.expectClose(CloseProtocolError)
+
+	client = dialWebSocket(t, ts.URL+"/ws", nil)
+	// 1005 must never be sent in a close frame
+	client.writeFrame(true, false, closeFrame, []byte{0x03, 0xed})
+	client.expectClose(CloseProtocolError)
+}
+
+func TestWebSocket_PerMessageDeflate(t *testing.T) {
+	server := &Server{ServerName: "WebSocket"}
+	server.Endpoints = append(server.Endpoints, []Endpoint{
+		{Path: "GET /ws", WebSocket: echoWebSocket(WebSocketOptions{Compression: true, CompressionThreshold: 1, FragmentSize: 8})},
+	})
+	ts := webSocketServer(t, server)
+
+	client := dialWebSocket(t, ts.URL+"/ws", http.Header{
+		"Sec-Websocket-Extensions": {"permessage-deflate; server_max_window_bits=10, permessage-deflate; client_max_window_bits"},
+	})
+	if extensions := client.response.Header.Get("Sec-WebSocket-Extensions"); !strings.HasPrefix(extensions, "permessage-deflate") {
+		t.Fatalf("Sec-WebSocket-Extensions = %q", extensions)
+	}
+
+	message := strings.Repeat("nexus ", 20)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write([]byte(message))
+	fw.Flush()
+	client.writeFrame(true, true, TextMessage, bytes.TrimSuffix(compressed.Bytes(), []byte{0, 0, 0xff, 0xff}))
+
+	var payload []byte
+	fin, rsv1, opcode, fragment := client.readFrame()
+	if opcode != TextMessage || !rsv1 {
+		t.Fatalf("first frame: opcode = %d, rsv1 = %v", opcode, rsv1)
+	}
+	payload = append(payload, fragment...)
+	for !fin {
+		fin, rsv1, opcode, fragment = client.readFrame()
+		if opcode != continuationFrame || rsv1 {
+			t.Fatalf("continuation: opcode = %d, rsv1 = %v", opcode, rsv1)
+		}
+		payload = append(payload, fragment...)
+	}
+
+	inflated, err := inflate(payload, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(inflated) != message {
+		t.Errorf("echo = %q", inflated)
+	}
+}